@@ -1,6 +1,7 @@
 package main
 
-// orderpub is a publisher of json orders to nats-streaming-server
+// orderpub is a publisher of json orders to nats-streaming-server.
+// The orders are published wrapped in the envelope of the current schema version (see package envelope).
 
 import (
 	"bufio"
@@ -10,12 +11,14 @@ import (
 	"os"
 
 	"github.com/nats-io/stan.go"
+	"github.com/vanamelnik/wildberries-L0/envelope"
 )
 
 const (
 	clusterName = "cluster-L0"
 	clientID    = "orderPub"
 	subject     = "orders"
+	producer    = "orderpub"
 )
 
 func main() {
@@ -32,10 +35,15 @@ func main() {
 	} else {
 		order = readFromFile()
 	}
-	if err := sc.Publish(subject, []byte(order)); err != nil {
+	env := envelope.New(producer, []byte(order))
+	msg, err := env.Marshal()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := sc.Publish(subject, msg); err != nil {
 		log.Fatal(err)
 	}
-	log.Println("Order sent")
+	log.Printf("Order sent, message ID %s", env.MessageID)
 }
 
 func readFromConsole() string {
@@ -2,15 +2,28 @@ package main
 
 // orderserver is a service that listens to nats-streaming-server
 // and stores all incoming oreders (from the subject "orders")
-// to the postgres database using in-memory cache
+// to the postgres database using in-memory cache.
+// The pending database migrations are applied on start.
+//
+// Usage:
+//	orderserver                           - run the service
+//	orderserver migrate status            - show the state of the database migrations
+//	orderserver migrate up                - apply all the pending migrations
+//	orderserver migrate to <version>      - migrate the database up or down to the given version
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vanamelnik/wildberries-L0/ingest"
 	"github.com/vanamelnik/wildberries-L0/nats_listener"
 	"github.com/vanamelnik/wildberries-L0/server"
 	"github.com/vanamelnik/wildberries-L0/storage/inmem"
@@ -24,40 +37,185 @@ const (
 	clientID    = "orderServer"
 	durableName = "orderSeverSub"
 	subject     = "orders"
+	// the subject for the rejected orders, see cmd/orderdlq
+	deadLetterSubject = "orders.rejected"
+	// the environment variable with the bearer token for the admin routes
+	adminTokenEnv = "ORDERSERVER_ADMIN_TOKEN"
+	// the environment variable with the policy for the fields unknown to the order model: lenient, warn (default) or strict
+	decodePolicyEnv = "ORDERSERVER_DECODE_POLICY"
+	// the environment variable that makes the server reject the orders missing any field if set to "true"
+	requireFieldsEnv = "ORDERSERVER_REQUIRE_FIELDS"
+
+	dbReadTimeout   = 5 * time.Second
+	dbWriteTimeout  = 5 * time.Second
+	shutdownTimeout = 10 * time.Second
+	natsAckWait     = 30 * time.Second
+
+	cacheMaxEntries = 100000
+	cacheMaxBytes   = 256 << 20
+	cacheTTL        = time.Hour
 )
 
 func main() {
-	pg, err := postgres.NewStorage(databaseURI)
+	if len(os.Args) > 1 {
+		if os.Args[1] != "migrate" {
+			usage()
+		}
+		migrate(os.Args[2:])
+		return
+	}
+	// ctx is canceled on the termination signal, so all in-flight work is interrupted.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	defer stop()
+
+	// the orders are stored synchronously, so the storing errors are reported to the cache.
+	pg, err := postgres.NewStorage(ctx, databaseURI,
+		postgres.WithMode(postgres.ModeSync),
+		postgres.WithReadTimeout(dbReadTimeout),
+		postgres.WithWriteTimeout(dbWriteTimeout),
+		// the orders are also written to the normalized tables for reporting, see orderadmin backfill
+		postgres.WithNormalizedSchema(),
+		postgres.WithMetrics(prometheus.DefaultRegisterer),
+	)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer logIfError(pg.Close)
 
-	s, err := inmem.NewCache(inmem.WithPersistentStorage(pg))
+	s, err := inmem.NewCache(
+		inmem.WithPersistentStorage(ctx, pg),
+		inmem.WithMaxEntries(cacheMaxEntries),
+		inmem.WithMaxBytes(cacheMaxBytes),
+		inmem.WithTTL(cacheTTL),
+		// the most recent orders are loaded while the server is already serving
+		inmem.WithWarmUp(ctx, inmem.WarmUp{Background: true}),
+		// the changes made by the other instances are applied to the cache
+		inmem.WithChangeNotifier(ctx, pg),
+		inmem.WithMetrics(prometheus.DefaultRegisterer),
+	)
 	must(err)
 
-	nl, err := nats_listener.New(clusterName, clientID, durableName, subject, s)
+	// the same ingestion service is used by the listener and the HTTP API, so the orders are checked the same way
+	ingestOpts := []ingest.ServiceOpt{
+		// a corrected re-publication of the order replaces the stored one
+		ingest.WithDuplicatePolicy(ingest.DuplicateReplace),
+		ingest.WithDecodePolicy(decodePolicy()),
+		ingest.WithMetrics(prometheus.DefaultRegisterer),
+	}
+	if os.Getenv(requireFieldsEnv) == "true" {
+		ingestOpts = append(ingestOpts, ingest.WithRequiredFields())
+	}
+	svc, err := ingest.New(s, ingestOpts...)
+	must(err)
+
+	nl, err := nats_listener.New(ctx, clusterName, clientID, durableName, subject, svc,
+		nats_listener.WithAckWait(natsAckWait),
+		nats_listener.WithDeadLetterSubject(deadLetterSubject),
+		nats_listener.WithMetrics(prometheus.DefaultRegisterer),
+	)
 	must(err)
 	defer logIfError(nl.Close)
 
 	log.Println("NATS Listener started")
 
-	server, err := server.New(addr, s)
+	serverOpts := []server.ServerOpt{
+		// the default registry also collects the Go runtime and process metrics
+		server.WithMetrics(prometheus.DefaultRegisterer, prometheus.DefaultGatherer),
+		server.WithHealthChecks(
+			server.HealthCheck{Name: "postgres", Check: pg.Ping},
+			server.HealthCheck{Name: "store_queue", Check: pg.CheckQueue},
+			server.HealthCheck{Name: "cache_warmup", Check: s.CheckWarmUp},
+			server.HealthCheck{Name: "stan", Check: nl.CheckConnection},
+			// the listener does not reconnect after the connection is lost, the server must be restarted
+			server.HealthCheck{Name: "stan_alive", Check: nl.CheckAlive, Liveness: true},
+		),
+	}
+	// the admin routes and the ingestion endpoint are enabled only if the token is provided
+	if token := os.Getenv(adminTokenEnv); token != "" {
+		serverOpts = append(serverOpts, server.WithAdminToken(token), server.WithIngest(svc))
+	} else {
+		log.Printf("%s is not set, the admin routes and the ingestion endpoint are disabled", adminTokenEnv)
+	}
+	server, err := server.New(addr, s, serverOpts...)
 	must(err)
+	server.BaseContext = func(net.Listener) context.Context { return ctx }
 	go logIfError(server.ListenAndServe)
 	log.Printf("HTTP server is listening at %s", addr)
 	defer func() {
-		if err := server.Shutdown(context.Background()); err != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
 			log.Println(err)
 		}
 	}()
 
-	sigint := make(chan os.Signal, 1)
-	signal.Notify(sigint, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
-	<-sigint
+	<-ctx.Done()
 	log.Println("Shutting down...")
 }
 
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: orderserver [migrate status | migrate up | migrate to <version>]")
+	os.Exit(2)
+}
+
+// migrate runs the migrate subcommand.
+func migrate(args []string) {
+	if len(args) < 1 {
+		usage()
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	m, err := postgres.NewMigrator(ctx, databaseURI)
+	must(err)
+	defer logIfError(m.Close)
+
+	switch args[0] {
+	case "status":
+		statuses, err := m.Status(ctx)
+		must(err)
+		for _, st := range statuses {
+			state := "pending"
+			if !st.AppliedAt.IsZero() {
+				state = "applied at " + st.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", st.Version, st.Name, state)
+		}
+	case "up":
+		applied, err := m.Up(ctx)
+		must(err)
+		log.Printf("%d migration(s) applied", len(applied))
+	case "to":
+		if len(args) < 2 {
+			usage()
+		}
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Fatalf("incorrect version: %s", err)
+		}
+		done, err := m.To(ctx, version)
+		must(err)
+		log.Printf("%d migration(s) run, the schema version is %d", len(done), version)
+	default:
+		usage()
+	}
+}
+
+// decodePolicy returns the decoding policy set by the environment variable. By default the fields unknown
+// to the order model are logged and counted, so the schema drift is noticed.
+func decodePolicy() ingest.DecodePolicy {
+	switch p := os.Getenv(decodePolicyEnv); p {
+	case "lenient":
+		return ingest.DecodeLenient
+	case "", "warn":
+		return ingest.DecodeWarn
+	case "strict":
+		return ingest.DecodeStrict
+	default:
+		log.Fatalf("unknown %s %q", decodePolicyEnv, p)
+		return 0
+	}
+}
+
 func must(err error) {
 	if err != nil {
 		log.Fatal(err)
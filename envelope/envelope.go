@@ -0,0 +1,115 @@
+package envelope
+
+// package envelope implements the versioned envelope of the order messages published to NATS streaming.
+// Every message carries the schema version of its payload, so the consumers could upcast the orders
+// published in the older formats to the current models.Order.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nuid"
+)
+
+// Schema versions.
+const (
+	// VersionBare is the bare order document published before the envelope was introduced.
+	// The messages without the envelope are considered to be of this version.
+	VersionBare = 1
+	// CurrentVersion is the version of the payloads matching models.Order.
+	CurrentVersion = 2
+)
+
+// ErrUnsupportedVersion is returned if the schema version of the message is unknown to the consumer,
+// e.g. the message is published by a newer producer.
+var ErrUnsupportedVersion = errors.New("unsupported schema version")
+
+type (
+	// Envelope wraps the order published to NATS streaming.
+	Envelope struct {
+		SchemaVersion int       `json:"schema_version"`
+		Producer      string    `json:"producer"`   // the name of the publishing application
+		MessageID     string    `json:"message_id"` // the unique ID of the message
+		Timestamp     time.Time `json:"timestamp"`  // the time the message was published
+		// Payload is the JSON order in the format of the schema version.
+		Payload json.RawMessage `json:"payload"`
+	}
+
+	// upcaster converts the payload of the schema version to the next version.
+	upcaster func(payload json.RawMessage) (json.RawMessage, error)
+)
+
+// upcasters are the converters of every previous schema version to the next one.
+// NB every change of the order format must increase CurrentVersion and add the upcaster
+// from the previous version, so the messages published by the older producers are still accepted.
+var upcasters = map[int]upcaster{
+	// the envelope did not change the order itself
+	VersionBare: func(payload json.RawMessage) (json.RawMessage, error) { return payload, nil },
+}
+
+// New wraps the order of the current schema version into the envelope with a new message ID.
+func New(producer string, order []byte) Envelope {
+	return Envelope{
+		SchemaVersion: CurrentVersion,
+		Producer:      producer,
+		MessageID:     nuid.Next(),
+		Timestamp:     time.Now().UTC(),
+		Payload:       json.RawMessage(order),
+	}
+}
+
+// Marshal encodes the envelope to be published.
+func (e Envelope) Marshal() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// Decode unwraps the message and upcasts its payload to the current schema version.
+// The message without the envelope is considered to be the bare order (VersionBare).
+// The returned envelope keeps the schema version the message was published with.
+// ErrUnsupportedVersion is returned if the payload could not be upcast; in this case as well as
+// on any other upcasting error, the envelope is returned without the payload, so the producer,
+// the message ID and the schema version of the rejected message could be reported.
+func Decode(data []byte) (Envelope, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return Envelope{}, fmt.Errorf("could not decode message: %w", err)
+	}
+	var e Envelope
+	if _, ok := fields["schema_version"]; ok {
+		if err := json.Unmarshal(data, &e); err != nil {
+			return Envelope{}, fmt.Errorf("could not decode envelope: %w", err)
+		}
+		if len(e.Payload) == 0 || string(e.Payload) == "null" {
+			return Envelope{}, errors.New("envelope has no payload")
+		}
+	} else {
+		e = Envelope{SchemaVersion: VersionBare, Payload: json.RawMessage(data)}
+	}
+	payload, err := upcast(e.SchemaVersion, e.Payload)
+	if err != nil {
+		e.Payload = nil
+		return e, err
+	}
+	e.Payload = payload
+	return e, nil
+}
+
+// upcast converts the payload of the schema version to the current version step by step.
+func upcast(version int, payload json.RawMessage) (json.RawMessage, error) {
+	if version < VersionBare || version > CurrentVersion {
+		return nil, fmt.Errorf("%w %d", ErrUnsupportedVersion, version)
+	}
+	for v := version; v < CurrentVersion; v++ {
+		up, ok := upcasters[v]
+		if !ok {
+			return nil, fmt.Errorf("%w %d: no upcaster to version %d", ErrUnsupportedVersion, version, v+1)
+		}
+		var err error
+		if payload, err = up(payload); err != nil {
+			return nil, fmt.Errorf("could not upcast payload from version %d to %d: %w", v, v+1, err)
+		}
+	}
+	return payload, nil
+}
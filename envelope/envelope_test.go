@@ -0,0 +1,94 @@
+package envelope
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecode(t *testing.T) {
+	order, err := os.ReadFile("../model.json")
+	require.NoError(t, err)
+
+	t.Run("Current version", func(t *testing.T) {
+		env := New("test", order)
+		assert.NotEmpty(t, env.MessageID)
+		assert.NotEqual(t, env.MessageID, New("test", order).MessageID, "the message IDs must be unique")
+		data, err := env.Marshal()
+		require.NoError(t, err)
+		got, err := Decode(data)
+		require.NoError(t, err)
+		assert.Equal(t, CurrentVersion, got.SchemaVersion)
+		assert.Equal(t, "test", got.Producer)
+		assert.Equal(t, env.MessageID, got.MessageID)
+		assert.True(t, env.Timestamp.Equal(got.Timestamp))
+		assert.JSONEq(t, string(order), string(got.Payload))
+	})
+	t.Run("Bare order", func(t *testing.T) {
+		got, err := Decode(order)
+		require.NoError(t, err)
+		assert.Equal(t, Envelope{SchemaVersion: VersionBare, Payload: order}, got)
+	})
+
+	tests := []struct {
+		name        string
+		data        string
+		unsupported bool
+	}{
+		{name: "Not a JSON", data: `order`},
+		{name: "Not an object", data: `[]`},
+		{name: "Malformed envelope", data: `{"schema_version": "2", "payload": {}}`},
+		{name: "No payload", data: `{"schema_version": 2}`},
+		{name: "Null payload", data: `{"schema_version": 2, "payload": null}`},
+		{name: "Zero version", data: `{"schema_version": 0, "payload": {}}`, unsupported: true},
+		{name: "Future version", data: `{"schema_version": 3, "payload": {}}`, unsupported: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			env, err := Decode([]byte(tc.data))
+			require.Error(t, err)
+			assert.Equal(t, tc.unsupported, errors.Is(err, ErrUnsupportedVersion))
+			assert.Nil(t, env.Payload)
+		})
+	}
+	t.Run("Header of unsupported version", func(t *testing.T) {
+		env, err := Decode([]byte(`{"schema_version": 3, "producer": "future", "message_id": "m1", "payload": {}}`))
+		require.ErrorIs(t, err, ErrUnsupportedVersion)
+		assert.Equal(t, Envelope{SchemaVersion: 3, Producer: "future", MessageID: "m1"}, env,
+			"the header must be reported along with the error")
+	})
+}
+
+func TestUpcast(t *testing.T) {
+	defer func(saved map[int]upcaster) { upcasters = saved }(upcasters)
+	// every upcaster appends its version to the list of the payload
+	step := func(v int) upcaster {
+		return func(payload json.RawMessage) (json.RawMessage, error) {
+			var steps []int
+			if err := json.Unmarshal(payload, &steps); err != nil {
+				return nil, err
+			}
+			return json.Marshal(append(steps, v))
+		}
+	}
+	upcasters = map[int]upcaster{VersionBare: step(VersionBare)}
+
+	got, err := upcast(VersionBare, json.RawMessage(`[]`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `[1]`, string(got))
+	got, err = upcast(CurrentVersion, json.RawMessage(`[]`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `[]`, string(got), "the current version must not be upcast")
+
+	_, err = upcast(VersionBare, json.RawMessage(`{}`))
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrUnsupportedVersion), "the upcaster error must be reported as it is")
+
+	upcasters = map[int]upcaster{}
+	_, err = upcast(VersionBare, json.RawMessage(`[]`))
+	assert.ErrorIs(t, err, ErrUnsupportedVersion, "the version without the upcaster could not be upcast")
+}
@@ -0,0 +1,270 @@
+package ingest
+
+// package ingest implements the pipeline every incoming order passes through regardless of the way
+// it enters the system (NATS streaming, HTTP API): unwrapping from the envelope, decoding, validation and storing.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/vanamelnik/wildberries-L0/envelope"
+	"github.com/vanamelnik/wildberries-L0/models"
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+// Rejection stages.
+const (
+	StageDecode    = "decode"
+	StageSchema    = "schema"
+	StageValidate  = "validate"
+	StageDuplicate = "duplicate"
+)
+
+// Decoding policies.
+const (
+	// DecodeLenient silently ignores the fields unknown to models.Order.
+	DecodeLenient DecodePolicy = iota
+	// DecodeWarn logs and counts the unknown fields and reports them in Result.Warnings.
+	DecodeWarn
+	// DecodeStrict rejects the orders with unknown fields at the schema stage.
+	DecodeStrict
+)
+
+// Duplicate policies.
+const (
+	// DuplicateReject keeps the stored order and rejects the new one.
+	DuplicateReject DuplicatePolicy = iota
+	// DuplicateReplace replaces the stored order with the new one (see storage.Storage.Upsert).
+	DuplicateReplace
+)
+
+type (
+	// Service decodes, validates and stores the incoming orders.
+	Service struct {
+		s               storage.Storage
+		duplicatePolicy DuplicatePolicy
+		decodePolicy    DecodePolicy
+		requireFields   bool
+		// metrics are registered by WithMetrics.
+		metrics serviceMetrics
+	}
+
+	ServiceOpt func(svc *Service) error
+
+	// DuplicatePolicy defines what the service does with the order whose UID is already stored.
+	DuplicatePolicy int
+
+	// DecodePolicy defines what the service does with the fields of the order unknown to models.Order.
+	// NB the unknown fields are stored anyway since the orders are stored as they are received.
+	DecodePolicy int
+
+	// Result describes the stored order.
+	Result struct {
+		OrderUID string
+		Version  int64
+		// Envelope is the envelope the order was received in with the payload upcast to the current version.
+		// It is set for the rejected orders as well: without the payload if the payload could not be upcast
+		// and empty if the envelope could not be decoded.
+		Envelope envelope.Envelope
+		// Warnings are the unknown fields of the order if the DecodeWarn policy is set.
+		Warnings models.ValidationErrors
+	}
+
+	// RejectError is returned if the order could never be stored as it is.
+	RejectError struct {
+		Stage    string // the stage of processing the order was rejected at
+		OrderUID string // empty if the order could not be decoded
+		Err      error
+	}
+)
+
+// New creates a new ingestion service storing the orders to the given storage.
+func New(s storage.Storage, opts ...ServiceOpt) (*Service, error) {
+	svc := &Service{s: s, metrics: newServiceMetrics()}
+	for _, opt := range opts {
+		if err := opt(svc); err != nil {
+			return nil, fmt.Errorf("ingest: could not apply option: %w", err)
+		}
+	}
+	return svc, nil
+}
+
+// WithDuplicatePolicy sets the policy for the orders with the UIDs that are already stored.
+// The default is DuplicateReject.
+func WithDuplicatePolicy(p DuplicatePolicy) ServiceOpt {
+	return func(svc *Service) error {
+		if p != DuplicateReject && p != DuplicateReplace {
+			return fmt.Errorf("unknown duplicate policy %d", p)
+		}
+		svc.duplicatePolicy = p
+		return nil
+	}
+}
+
+// WithDecodePolicy sets the policy for the fields unknown to models.Order. The default is DecodeLenient.
+func WithDecodePolicy(p DecodePolicy) ServiceOpt {
+	return func(svc *Service) error {
+		if p != DecodeLenient && p != DecodeWarn && p != DecodeStrict {
+			return fmt.Errorf("unknown decode policy %d", p)
+		}
+		svc.decodePolicy = p
+		return nil
+	}
+}
+
+// WithRequiredFields makes the service reject the orders that miss any field of models.Order
+// at the schema stage. The fields with null values are considered present.
+func WithRequiredFields() ServiceOpt {
+	return func(svc *Service) error {
+		svc.requireFields = true
+		return nil
+	}
+}
+
+// Ingest unwraps the order from the envelope (see envelope.Decode), decodes, validates and stores it.
+// The order is stored without the envelope in the current schema version. If the personal data
+// of the order have been erased (see storage.AuditLog), they are erased from the order being stored as well.
+// *RejectError is returned if the message or the order is invalid or it is a rejected duplicate;
+// any other error is the storage error, so the order could be ingested later.
+func (svc *Service) Ingest(ctx context.Context, data []byte) (Result, error) {
+	env, err := envelope.Decode(data)
+	if err != nil {
+		return Result{Envelope: env}, &RejectError{Stage: StageDecode, Err: err}
+	}
+	svc.metrics.schemaVersions.WithLabelValues(strconv.Itoa(env.SchemaVersion)).Inc()
+	res := Result{Envelope: env}
+	order, warnings, err := svc.check(env.Payload)
+	if err != nil {
+		return res, err
+	}
+	res.OrderUID, res.Warnings = order.OrderUID, warnings
+	jsonOrder, err := svc.erasePII(ctx, order.OrderUID, string(env.Payload))
+	if err != nil {
+		return res, err
+	}
+	if svc.duplicatePolicy == DuplicateReplace {
+		res.Version, err = svc.s.Upsert(ctx, order.OrderUID, jsonOrder)
+		return res, err
+	}
+	if err := svc.s.Store(ctx, order.OrderUID, jsonOrder); err != nil {
+		if errors.Is(err, storage.ErrAlreadyExists) {
+			return res, &RejectError{Stage: StageDuplicate, OrderUID: order.OrderUID, Err: err}
+		}
+		return res, err
+	}
+	res.Version = 1
+	return res, nil
+}
+
+// erasePII returns the order with the personal data erased if the erasure of the order is recorded
+// to the audit log of the storage, so the original payload received again (e.g. the redelivered
+// or re-published message) does not restore the erased data. The order is returned as is
+// if the storage has no audit log.
+func (svc *Service) erasePII(ctx context.Context, orderUID, jsonOrder string) (string, error) {
+	al, ok := svc.s.(storage.AuditLog)
+	if !ok {
+		return jsonOrder, nil
+	}
+	erasures, err := al.Erasures(ctx, orderUID)
+	if errors.Is(err, storage.ErrNoAuditLog) {
+		return jsonOrder, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("ingest: could not read the erasures of order %q: %w", orderUID, err)
+	}
+	if len(erasures) == 0 {
+		return jsonOrder, nil
+	}
+	log.Printf("ingest: personal data of order %q have been erased, erasing them from the received order", orderUID)
+	return models.ErasePII(jsonOrder)
+}
+
+// Check unwraps the order from the envelope, decodes and validates it without storing
+// using the default (lenient) decoding policy. *RejectError is returned if the message or the order is invalid.
+func Check(data []byte) (models.Order, error) {
+	env, err := envelope.Decode(data)
+	if err != nil {
+		return models.Order{}, &RejectError{Stage: StageDecode, Err: err}
+	}
+	order, _, err := (&Service{}).check(env.Payload)
+	return order, err
+}
+
+// check decodes the order, checks its fields according to the decoding policy and validates it.
+// The unknown fields are returned as warnings if the DecodeWarn policy is set.
+func (svc *Service) check(data []byte) (models.Order, models.ValidationErrors, error) {
+	var order models.Order
+	if err := json.Unmarshal(data, &order); err != nil {
+		return models.Order{}, nil, &RejectError{Stage: StageDecode, Err: err}
+	}
+	var warnings models.ValidationErrors
+	if svc.decodePolicy != DecodeLenient || svc.requireFields {
+		unknown, missing, err := models.CheckFields(data)
+		if err != nil {
+			return models.Order{}, nil, &RejectError{Stage: StageDecode, Err: err}
+		}
+		var errs models.ValidationErrors
+		for _, path := range unknown {
+			switch svc.decodePolicy {
+			case DecodeStrict:
+				errs = append(errs, models.NewValidationError(path, models.CodeUnknownField))
+			case DecodeWarn:
+				warnings = append(warnings, models.NewValidationError(path, models.CodeUnknownField))
+				svc.metrics.unknownFields.WithLabelValues(unknownFieldObject(path)).Inc()
+			}
+		}
+		if svc.requireFields {
+			for _, path := range missing {
+				errs = append(errs, models.NewValidationError(path, models.CodeMissingField))
+			}
+		}
+		if len(errs) > 0 {
+			return models.Order{}, nil, &RejectError{Stage: StageSchema, OrderUID: order.OrderUID, Err: errs}
+		}
+		if len(warnings) > 0 {
+			log.Printf("ingest: WARN: order %q has unknown fields: %s", order.OrderUID, strings.Join(unknown, ", "))
+		}
+	}
+	if err := order.Validate(); err != nil {
+		return models.Order{}, nil, &RejectError{Stage: StageValidate, OrderUID: order.OrderUID, Err: err}
+	}
+	return order, warnings, nil
+}
+
+func (e *RejectError) Error() string {
+	if e.OrderUID == "" {
+		return fmt.Sprintf("order rejected at %s stage: %s", e.Stage, e.Err)
+	}
+	return fmt.Sprintf("order %q rejected at %s stage: %s", e.OrderUID, e.Stage, e.Err)
+}
+
+func (e *RejectError) Unwrap() error {
+	return e.Err
+}
+
+// Errors returns the list of all the error messages, e.g. every validation error.
+func (e *RejectError) Errors() []string {
+	violations := e.Violations()
+	if violations == nil {
+		return []string{e.Err.Error()}
+	}
+	list := make([]string, 0, len(violations))
+	for _, v := range violations {
+		list = append(list, v.Error())
+	}
+	return list
+}
+
+// Violations returns the validation errors if the order is rejected at the schema or validation stage, otherwise nil.
+func (e *RejectError) Violations() models.ValidationErrors {
+	var errs models.ValidationErrors
+	if !errors.As(e.Err, &errs) {
+		return nil
+	}
+	return errs
+}
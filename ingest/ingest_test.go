@@ -0,0 +1,259 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/wildberries-L0/envelope"
+	"github.com/vanamelnik/wildberries-L0/internal/testorder"
+	"github.com/vanamelnik/wildberries-L0/models"
+	"github.com/vanamelnik/wildberries-L0/storage"
+	"github.com/vanamelnik/wildberries-L0/storage/inmem"
+)
+
+func TestIngest(t *testing.T) {
+	ctx := context.Background()
+	c, err := inmem.NewCache()
+	require.NoError(t, err)
+	svc, err := New(c)
+	require.NoError(t, err)
+
+	order := testorder.New(t, "ingest-1", nil)
+	res, err := svc.Ingest(ctx, order)
+	require.NoError(t, err)
+	assert.Equal(t, "ingest-1", res.OrderUID)
+	assert.Equal(t, int64(1), res.Version)
+	assert.Equal(t, envelope.VersionBare, res.Envelope.SchemaVersion, "the bare order must be accepted")
+	got, err := c.Get(ctx, "ingest-1")
+	require.NoError(t, err)
+	assert.JSONEq(t, string(order), got)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		stage    string
+		orderUID string
+		errors   int
+	}{
+		{name: "Decode error", data: []byte(`{"order_uid": 42}`), stage: StageDecode, errors: 1},
+		{name: "Not a JSON", data: []byte(`order`), stage: StageDecode, errors: 1},
+		{name: "Unsupported schema version", data: []byte(`{"schema_version": 42, "payload": {}}`), stage: StageDecode, errors: 1},
+		{
+			name:     "Validation errors",
+			data:     testorder.New(t, "ingest-2", map[string]interface{}{"delivery": map[string]string{"email": "wrong"}}),
+			stage:    StageValidate,
+			orderUID: "ingest-2",
+			errors:   2, // email and phone
+		},
+		{name: "Duplicate", data: order, stage: StageDuplicate, orderUID: "ingest-1", errors: 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := svc.Ingest(ctx, tc.data)
+			var rejectErr *RejectError
+			require.ErrorAs(t, err, &rejectErr)
+			assert.Equal(t, tc.stage, rejectErr.Stage)
+			assert.Equal(t, tc.orderUID, rejectErr.OrderUID)
+			assert.Len(t, rejectErr.Errors(), tc.errors)
+		})
+	}
+	_, err = c.Get(ctx, "ingest-2")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestIngestEnvelope(t *testing.T) {
+	ctx := context.Background()
+	c, err := inmem.NewCache()
+	require.NoError(t, err)
+	svc, err := New(c)
+	require.NoError(t, err)
+
+	order := testorder.New(t, "ingest-1", nil)
+	env := envelope.New("test", order)
+	msg, err := env.Marshal()
+	require.NoError(t, err)
+	res, err := svc.Ingest(ctx, msg)
+	require.NoError(t, err)
+	assert.Equal(t, "ingest-1", res.OrderUID)
+	assert.Equal(t, envelope.CurrentVersion, res.Envelope.SchemaVersion)
+	assert.Equal(t, "test", res.Envelope.Producer)
+	assert.Equal(t, env.MessageID, res.Envelope.MessageID)
+	got, err := c.Get(ctx, "ingest-1")
+	require.NoError(t, err)
+	assert.JSONEq(t, string(order), got, "the order must be stored without the envelope")
+	assert.Equal(t, 1.0, testutil.ToFloat64(svc.metrics.schemaVersions.WithLabelValues(strconv.Itoa(envelope.CurrentVersion))))
+
+	t.Run("Rejected", func(t *testing.T) {
+		env := envelope.New("test", testorder.New(t, "ingest-2", map[string]interface{}{"locale": "xx"}))
+		msg, err := env.Marshal()
+		require.NoError(t, err)
+		res, err := svc.Ingest(ctx, msg)
+		var rejectErr *RejectError
+		require.ErrorAs(t, err, &rejectErr)
+		assert.Equal(t, StageValidate, rejectErr.Stage)
+		assert.Equal(t, env.MessageID, res.Envelope.MessageID, "the envelope must be reported for the rejected order")
+	})
+}
+
+func TestIngestReplace(t *testing.T) {
+	ctx := context.Background()
+	c, err := inmem.NewCache()
+	require.NoError(t, err)
+	svc, err := New(c, WithDuplicatePolicy(DuplicateReplace))
+	require.NoError(t, err)
+
+	_, err = svc.Ingest(ctx, testorder.New(t, "ingest-1", nil))
+	require.NoError(t, err)
+	corrected := testorder.New(t, "ingest-1", map[string]interface{}{"entry": "CORRECTED"})
+	res, err := svc.Ingest(ctx, corrected)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), res.Version)
+	got, err := c.Get(ctx, "ingest-1")
+	require.NoError(t, err)
+	assert.JSONEq(t, string(corrected), got)
+}
+
+func TestIngestErased(t *testing.T) {
+	ctx := context.Background()
+	order := testorder.New(t, "ingest-1", nil)
+	assertErased := func(t *testing.T, c *inmem.Cache) {
+		got, err := c.Get(ctx, "ingest-1")
+		require.NoError(t, err)
+		var o models.Order
+		require.NoError(t, json.Unmarshal([]byte(got), &o))
+		assert.Empty(t, o.Delivery.Name)
+		assert.Empty(t, o.Delivery.Phone)
+		assert.Empty(t, o.Delivery.Email)
+		assert.Empty(t, o.Delivery.Address)
+	}
+	erased := func(t *testing.T, opts ...ServiceOpt) (*Service, *inmem.Cache, int64) {
+		c, err := inmem.NewCache()
+		require.NoError(t, err)
+		svc, err := New(c, opts...)
+		require.NoError(t, err)
+		_, err = svc.Ingest(ctx, order)
+		require.NoError(t, err)
+		version, err := c.ErasePII(ctx, "ingest-1", storage.Erasure{Requester: "dpo", Reason: "test"})
+		require.NoError(t, err)
+		return svc, c, version
+	}
+
+	t.Run("Replace", func(t *testing.T) {
+		svc, c, version := erased(t, WithDuplicatePolicy(DuplicateReplace))
+		res, err := svc.Ingest(ctx, order)
+		require.NoError(t, err)
+		assert.Equal(t, version, res.Version, "the erased order must be left intact")
+		assertErased(t, c)
+	})
+	t.Run("Stored again", func(t *testing.T) {
+		svc, c, _ := erased(t)
+		require.NoError(t, c.Delete(ctx, "ingest-1"))
+		_, err := svc.Ingest(ctx, order)
+		require.NoError(t, err)
+		assertErased(t, c)
+	})
+}
+
+// failingStorage is a storage that could not store anything.
+type failingStorage struct {
+	*inmem.Cache
+}
+
+var errDBDown = errors.New("database is down")
+
+func (failingStorage) Store(ctx context.Context, orderUID, jsonOrder string) error {
+	return errDBDown
+}
+
+func TestIngestStorageError(t *testing.T) {
+	c, err := inmem.NewCache()
+	require.NoError(t, err)
+	svc, err := New(failingStorage{c})
+	require.NoError(t, err)
+
+	res, err := svc.Ingest(context.Background(), testorder.New(t, "ingest-1", nil))
+	assert.ErrorIs(t, err, errDBDown)
+	var rejectErr *RejectError
+	assert.False(t, errors.As(err, &rejectErr), "the storage error must not be a rejection")
+	assert.Equal(t, "ingest-1", res.OrderUID)
+}
+
+func TestWithDuplicatePolicy(t *testing.T) {
+	_, err := New(nil, WithDuplicatePolicy(DuplicatePolicy(42)))
+	assert.Error(t, err)
+}
+
+func TestWithMetrics(t *testing.T) {
+	_, err := New(nil, WithMetrics(nil))
+	assert.Error(t, err)
+}
+
+func TestDecodePolicy(t *testing.T) {
+	ctx := context.Background()
+	data := testorder.New(t, "ingest-unknown", map[string]interface{}{"gift_wrap": true, "coupon.code": "SALE"})
+	var order map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &order))
+	order["items"].([]interface{})[0].(map[string]interface{})["color"] = "red"
+	data, err := json.Marshal(order)
+	require.NoError(t, err)
+	unknown := models.ValidationErrors{
+		models.NewValidationError("items[0].color", models.CodeUnknownField),
+		models.NewValidationError("coupon.code", models.CodeUnknownField),
+		models.NewValidationError("gift_wrap", models.CodeUnknownField),
+	}
+
+	newService := func(t *testing.T, opts ...ServiceOpt) *Service {
+		c, err := inmem.NewCache()
+		require.NoError(t, err)
+		svc, err := New(c, opts...)
+		require.NoError(t, err)
+		return svc
+	}
+
+	t.Run("Lenient", func(t *testing.T) {
+		res, err := newService(t).Ingest(ctx, data)
+		require.NoError(t, err)
+		assert.Empty(t, res.Warnings)
+	})
+	t.Run("Warn", func(t *testing.T) {
+		svc := newService(t, WithDecodePolicy(DecodeWarn))
+		res, err := svc.Ingest(ctx, data)
+		require.NoError(t, err)
+		assert.Equal(t, unknown, res.Warnings)
+		assert.Equal(t, 2.0, testutil.ToFloat64(svc.metrics.unknownFields.WithLabelValues("order")))
+		assert.Equal(t, 1.0, testutil.ToFloat64(svc.metrics.unknownFields.WithLabelValues("items")))
+		assert.Equal(t, 2, testutil.CollectAndCount(svc.metrics.unknownFields),
+			"the payload keys must not become the label values")
+	})
+	t.Run("Strict", func(t *testing.T) {
+		_, err := newService(t, WithDecodePolicy(DecodeStrict)).Ingest(ctx, data)
+		var rejectErr *RejectError
+		require.ErrorAs(t, err, &rejectErr)
+		assert.Equal(t, StageSchema, rejectErr.Stage)
+		assert.Equal(t, unknown, rejectErr.Violations())
+	})
+	t.Run("Required fields", func(t *testing.T) {
+		svc := newService(t, WithRequiredFields())
+		_, err := svc.Ingest(ctx, data)
+		require.NoError(t, err)
+
+		var order map[string]interface{}
+		require.NoError(t, json.Unmarshal(testorder.New(t, "ingest-required", nil), &order))
+		delete(order, "sm_id")
+		incomplete, err := json.Marshal(order)
+		require.NoError(t, err)
+		_, err = svc.Ingest(ctx, incomplete)
+		var rejectErr *RejectError
+		require.ErrorAs(t, err, &rejectErr)
+		assert.Equal(t, StageSchema, rejectErr.Stage)
+		assert.Equal(t, "ingest-required", rejectErr.OrderUID)
+		assert.Equal(t, models.ValidationErrors{models.NewValidationError("sm_id", models.CodeMissingField)},
+			rejectErr.Violations())
+	})
+}
@@ -0,0 +1,64 @@
+package ingest
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// objectOrder is the metric label of the unknown fields of the order itself.
+const objectOrder = "order"
+
+// nestedObjects are the objects of models.Order the unknown fields could be nested in.
+var nestedObjects = map[string]bool{"delivery": true, "payment": true, "items": true}
+
+// serviceMetrics are the counters updated by the service.
+type serviceMetrics struct {
+	unknownFields *prometheus.CounterVec
+	// schemaVersions counts the decoded envelopes, the unsupported versions are rejected before counting.
+	schemaVersions *prometheus.CounterVec
+}
+
+func newServiceMetrics() serviceMetrics {
+	return serviceMetrics{
+		unknownFields: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "orderserver",
+			Subsystem: "ingest",
+			Name:      "unknown_fields_total",
+			Help:      "The number of the unknown fields found in the orders by the object of the order they are found in.",
+		}, []string{"object"}),
+		schemaVersions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "orderserver",
+			Subsystem: "ingest",
+			Name:      "messages_schema_version_total",
+			Help:      "The number of the received orders by the schema version of the envelope they were sent in.",
+		}, []string{"version"}),
+	}
+}
+
+// unknownFieldObject returns the metric label of the unknown field: the nested object of the order
+// the field is found in (see nestedObjects) or objectOrder. The paths come from the payload,
+// so the label is chosen from the fixed set to keep the number of the series bounded.
+func unknownFieldObject(path string) string {
+	if i := strings.IndexAny(path, ".["); i > 0 && nestedObjects[path[:i]] {
+		return path[:i]
+	}
+	return objectOrder
+}
+
+// WithMetrics registers the metrics of the service: the schema versions of the received orders
+// and the unknown fields found with the DecodeWarn policy.
+func WithMetrics(reg prometheus.Registerer) ServiceOpt {
+	return func(svc *Service) error {
+		if reg == nil {
+			return errors.New("nil metrics registry")
+		}
+		for _, c := range []prometheus.Collector{svc.metrics.unknownFields, svc.metrics.schemaVersions} {
+			if err := reg.Register(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
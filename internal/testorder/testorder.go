@@ -0,0 +1,43 @@
+package testorder
+
+// package testorder provides the valid orders for the tests based on the sample model.json.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// New returns the valid order with the given UID and the top-level fields replaced by changes.
+func New(tb testing.TB, uid string, changes map[string]interface{}) []byte {
+	tb.Helper()
+	_, file, _, ok := runtime.Caller(0)
+	require.True(tb, ok, "could not locate the sample order")
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(file), "..", "..", "model.json"))
+	require.NoError(tb, err)
+	var order map[string]interface{}
+	require.NoError(tb, json.Unmarshal(data, &order))
+	order["order_uid"] = uid
+	for k, v := range changes {
+		order[k] = v
+	}
+	data, err = json.Marshal(order)
+	require.NoError(tb, err)
+	return data
+}
+
+// Batch returns n valid orders by their UIDs made of the test name.
+func Batch(tb testing.TB, n int) map[string][]byte {
+	tb.Helper()
+	orders := make(map[string][]byte, n)
+	for i := 0; i < n; i++ {
+		uid := fmt.Sprintf("%s-%d", tb.Name(), i)
+		orders[uid] = New(tb, uid, nil)
+	}
+	return orders
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// PIIFields are the fields of Delivery that contain the personal data of the customer.
+var PIIFields = []string{"name", "phone", "email", "address"}
+
+// ErasePII returns the JSON order with the personal data of the customer (see PIIFields) erased.
+// All the other fields, including the unknown ones, are kept as is.
+func ErasePII(jsonOrder string) (string, error) {
+	var order map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(jsonOrder), &order); err != nil {
+		return "", fmt.Errorf("could not decode order: %w", err)
+	}
+	var delivery map[string]json.RawMessage
+	if raw, ok := order["delivery"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &delivery); err != nil {
+			return "", fmt.Errorf("could not decode delivery: %w", err)
+		}
+	}
+	if delivery == nil {
+		return jsonOrder, nil
+	}
+	for _, field := range PIIFields {
+		if _, ok := delivery[field]; ok {
+			delivery[field] = json.RawMessage(`""`)
+		}
+	}
+	raw, err := json.Marshal(delivery)
+	if err != nil {
+		return "", err
+	}
+	order["delivery"] = raw
+	erased, err := json.Marshal(order)
+	if err != nil {
+		return "", err
+	}
+	return string(erased), nil
+}
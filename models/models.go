@@ -1,24 +1,9 @@
 package models
 
 import (
-	"errors"
-	"regexp"
 	"time"
-
-	"github.com/hashicorp/go-multierror"
-)
-
-// Validation regexs
-var (
-	phoneNumRegex *regexp.Regexp
-	emailRegex    *regexp.Regexp
 )
 
-func init() {
-	phoneNumRegex = regexp.MustCompile(`^[+]*[(]{0,1}[0-9]{1,4}[)]{0,1}[-\s\./0-9]*$`)
-	emailRegex = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
-}
-
 type (
 	// Order represents an order received by the message broker.
 	Order struct {
@@ -30,6 +15,8 @@ type (
 		Items             []Item    `json:"items"`
 		Locale            string    `json:"locale"`
 		InternalSignature string    `json:"internal_signature"`
+		CustomerID        string    `json:"customer_id"`
+		DeliveryService   string    `json:"delivery_service"`
 		Shardkey          string    `json:"shardkey"`
 		SmID              int       `json:"sm_id"`
 		DateCreated       time.Time `json:"date_created"`
@@ -73,22 +60,3 @@ type (
 		Status      int    `json:"status"`
 	}
 )
-
-func (o Order) Validate() error {
-	var err error
-	if o.OrderUID == "" {
-		err = multierror.Append(err, errors.New("empty order UID"))
-	}
-	if o.Payment.Transaction == "" {
-		err = multierror.Append(err, errors.New("empty payment transaction field"))
-	}
-	if !emailRegex.MatchString(o.Delivery.Email) {
-		err = multierror.Append(err, errors.New("incorrect delivery email"))
-	}
-	if !phoneNumRegex.MatchString(o.Delivery.Phone) {
-		err = multierror.Append(err, errors.New("incorrect delivery phone number"))
-	}
-	// TODO: add other validation for other fields
-
-	return err
-}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// CheckFields compares the JSON order with the fields of Order. The fields that are not known to Order
+// and the fields of Order missing in the JSON are returned as the JSON paths, e.g. "items[0].brand".
+// The fields with null values are considered present.
+func CheckFields(jsonOrder []byte) (unknown, missing []string, err error) {
+	dec := json.NewDecoder(bytes.NewReader(jsonOrder))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, nil, err
+	}
+	walkFields(v, reflect.TypeOf(Order{}), "", &unknown, &missing)
+	return unknown, missing, nil
+}
+
+// walkFields compares the decoded JSON value with the type recursively.
+// The values of the wrong types are skipped since they are reported by json.Unmarshal.
+func walkFields(v interface{}, t reflect.Type, path string, unknown, missing *[]string) {
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return
+		}
+		known := make(map[string]bool, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			name := jsonName(t.Field(i))
+			if name == "" {
+				continue
+			}
+			known[name] = true
+			fieldPath := joinPath(path, name)
+			value, ok := obj[name]
+			if !ok {
+				*missing = append(*missing, fieldPath)
+				continue
+			}
+			walkFields(value, t.Field(i).Type, fieldPath, unknown, missing)
+		}
+		var extra []string
+		for name := range obj {
+			if !known[name] {
+				extra = append(extra, joinPath(path, name))
+			}
+		}
+		sort.Strings(extra)
+		*unknown = append(*unknown, extra...)
+	case reflect.Slice:
+		arr, ok := v.([]interface{})
+		if !ok {
+			return
+		}
+		for i, elem := range arr {
+			walkFields(elem, t.Elem(), fmt.Sprintf("%s[%d]", path, i), unknown, missing)
+		}
+	}
+}
+
+// jsonName returns the name of the struct field in JSON, empty if the field is not encoded.
+func jsonName(f reflect.StructField) string {
+	if f.PkgPath != "" {
+		return ""
+	}
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name
+	}
+	return f.Name
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckFields(t *testing.T) {
+	for _, file := range []string{"../model.json", "../model1.json", "../model2.json"} {
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		unknown, missing, err := CheckFields(data)
+		require.NoError(t, err)
+		assert.Empty(t, unknown, "the sample %s must match the model", file)
+		assert.Empty(t, missing, "the sample %s must match the model", file)
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		unknown []string
+		missing []string
+	}{
+		{
+			name:    "Nested",
+			data:    `{"delivery": {"name": "", "foo": 1}, "items": [{"chrt_id": 1}, {"bar": null}], "date_created": "2021-11-26T06:22:19Z"}`,
+			unknown: []string{"delivery.foo", "items[1].bar"},
+			missing: []string{"order_uid", "track_number", "entry", "delivery.phone", "delivery.zip", "delivery.city",
+				"delivery.address", "delivery.region", "delivery.email", "payment", "items[0].track_number",
+				"items[0].price", "items[0].rid", "items[0].name", "items[0].sale", "items[0].size", "items[0].total_price",
+				"items[0].nm_id", "items[0].brand", "items[0].status", "items[1].chrt_id", "items[1].track_number",
+				"items[1].price", "items[1].rid", "items[1].name", "items[1].sale", "items[1].size", "items[1].total_price",
+				"items[1].nm_id", "items[1].brand", "items[1].status", "locale", "internal_signature", "customer_id",
+				"delivery_service", "shardkey", "sm_id", "oof_shard"},
+		},
+		{
+			name: "Null and wrong types",
+			data: `{"delivery": null, "items": {"a": 1}}`,
+			missing: []string{"order_uid", "track_number", "entry", "payment", "locale", "internal_signature",
+				"customer_id", "delivery_service", "shardkey", "sm_id", "date_created", "oof_shard"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			unknown, missing, err := CheckFields([]byte(tc.data))
+			require.NoError(t, err)
+			assert.Equal(t, tc.unknown, unknown)
+			assert.Equal(t, tc.missing, missing)
+		})
+	}
+
+	_, _, err := CheckFields([]byte(`{`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,210 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxClockSkew is the allowed difference between the clocks of the order producer and the validator,
+// so an order created just now is not rejected as created in the future.
+const maxClockSkew = 5 * time.Minute
+
+// Validation regexs
+var (
+	phoneNumRegex = regexp.MustCompile(`^[+]*[(]{0,1}[0-9]{1,4}[)]{0,1}[-\s\./0-9]*$`)
+	emailRegex    = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+)
+
+// Validation error codes.
+const (
+	CodeRequired            = "required"
+	CodeInvalidFormat       = "invalid_format"
+	CodeNegative            = "negative"
+	CodeOutOfRange          = "out_of_range"
+	CodeUnknownCurrency     = "unknown_currency"
+	CodeUnknownLocale       = "unknown_locale"
+	CodeInFuture            = "in_future"
+	CodeGoodsTotalMismatch  = "goods_total_mismatch"
+	CodeAmountMismatch      = "amount_mismatch"
+	CodeTotalPriceMismatch  = "total_price_mismatch"
+	CodeTrackNumberMismatch = "track_number_mismatch"
+	// the codes of the schema errors, see CheckFields
+	CodeUnknownField = "unknown_field"
+	CodeMissingField = "missing_field"
+)
+
+// validationMessages are the human readable messages of the validation error codes.
+var validationMessages = map[string]string{
+	CodeRequired:            "must not be empty",
+	CodeInvalidFormat:       "invalid format",
+	CodeNegative:            "must not be negative",
+	CodeOutOfRange:          "out of range",
+	CodeUnknownCurrency:     "unknown currency",
+	CodeUnknownLocale:       "unknown locale",
+	CodeInFuture:            "must not be in the future",
+	CodeGoodsTotalMismatch:  "must equal the sum of the items total prices",
+	CodeAmountMismatch:      "must equal the goods total plus the delivery cost and the custom fee",
+	CodeTotalPriceMismatch:  "must equal the price with the sale applied",
+	CodeTrackNumberMismatch: "must equal the track number of the order",
+	CodeUnknownField:        "unknown field",
+	CodeMissingField:        "missing field",
+}
+
+type (
+	// ValidationError is the violation of a validation rule by the order field.
+	ValidationError struct {
+		Path    string `json:"path"`    // the JSON path of the field, e.g. "items[2].total_price"
+		Code    string `json:"code"`    // one of the Code* constants
+		Message string `json:"message"` // the human readable description of the rule
+	}
+
+	// ValidationErrors is the list of all the violations found in the order.
+	ValidationErrors []ValidationError
+)
+
+// NewValidationError returns the error with the given path and code.
+func NewValidationError(path, code string) ValidationError {
+	return ValidationError{Path: path, Code: code, Message: validationMessages[code]}
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		msgs = append(msgs, e.Error())
+	}
+	return fmt.Sprintf("%d validation error(s): %s", len(errs), strings.Join(msgs, "; "))
+}
+
+// Currencies are the known ISO 4217 currency codes.
+var Currencies = setOf(
+	"AED", "AFN", "ALL", "AMD", "ANG", "AOA", "ARS", "AUD", "AWG", "AZN", "BAM", "BBD", "BDT", "BGN", "BHD",
+	"BIF", "BMD", "BND", "BOB", "BRL", "BSD", "BTN", "BWP", "BYN", "BZD", "CAD", "CDF", "CHF", "CLP", "CNY",
+	"COP", "CRC", "CUP", "CVE", "CZK", "DJF", "DKK", "DOP", "DZD", "EGP", "ERN", "ETB", "EUR", "FJD", "FKP",
+	"GBP", "GEL", "GHS", "GIP", "GMD", "GNF", "GTQ", "GYD", "HKD", "HNL", "HTG", "HUF", "IDR", "ILS", "INR",
+	"IQD", "IRR", "ISK", "JMD", "JOD", "JPY", "KES", "KGS", "KHR", "KMF", "KPW", "KRW", "KWD", "KYD", "KZT",
+	"LAK", "LBP", "LKR", "LRD", "LSL", "LYD", "MAD", "MDL", "MGA", "MKD", "MMK", "MNT", "MOP", "MRU", "MUR",
+	"MVR", "MWK", "MXN", "MYR", "MZN", "NAD", "NGN", "NIO", "NOK", "NPR", "NZD", "OMR", "PAB", "PEN", "PGK",
+	"PHP", "PKR", "PLN", "PYG", "QAR", "RON", "RSD", "RUB", "RWF", "SAR", "SBD", "SCR", "SDG", "SEK", "SGD",
+	"SHP", "SLE", "SOS", "SRD", "SSP", "STN", "SVC", "SYP", "SZL", "THB", "TJS", "TMT", "TND", "TOP", "TRY",
+	"TTD", "TWD", "TZS", "UAH", "UGX", "USD", "UYU", "UZS", "VES", "VND", "VUV", "WST", "XAF", "XCD", "XOF",
+	"XPF", "YER", "ZAR", "ZMW", "ZWL",
+)
+
+// Locales are the locales the orders could be made in.
+var Locales = setOf("en", "ru", "be", "kk", "ky", "hy", "uz", "az", "ka", "tr", "de", "fr", "es", "it", "pl", "zh")
+
+func setOf(values ...string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
+
+// Validate checks the order against all the validation rules. All the violations are returned
+// as ValidationErrors.
+func (o Order) Validate() error {
+	return o.validate(time.Now())
+}
+
+// validate checks the order, now is the current time.
+func (o Order) validate(now time.Time) error {
+	var errs ValidationErrors
+	fail := func(path, code string) {
+		errs = append(errs, NewValidationError(path, code))
+	}
+
+	if o.OrderUID == "" {
+		fail("order_uid", CodeRequired)
+	}
+	if o.TrackNumber == "" {
+		fail("track_number", CodeRequired)
+	}
+	if o.CustomerID == "" {
+		fail("customer_id", CodeRequired)
+	}
+	if o.DeliveryService == "" {
+		fail("delivery_service", CodeRequired)
+	}
+	if !Locales[o.Locale] {
+		fail("locale", CodeUnknownLocale)
+	}
+	switch {
+	case o.DateCreated.IsZero():
+		fail("date_created", CodeRequired)
+	case o.DateCreated.After(now.Add(maxClockSkew)):
+		fail("date_created", CodeInFuture)
+	}
+
+	if !emailRegex.MatchString(o.Delivery.Email) {
+		fail("delivery.email", CodeInvalidFormat)
+	}
+	if !phoneNumRegex.MatchString(o.Delivery.Phone) {
+		fail("delivery.phone", CodeInvalidFormat)
+	}
+
+	p := o.Payment
+	if p.Transaction == "" {
+		fail("payment.transaction", CodeRequired)
+	}
+	if !Currencies[p.Currency] {
+		fail("payment.currency", CodeUnknownCurrency)
+	}
+	for _, f := range []struct {
+		field string
+		value int
+	}{
+		{"payment.amount", p.Amount},
+		{"payment.delivery_cost", p.DeliveryCost},
+		{"payment.goods_total", p.GoodsTotal},
+		{"payment.custom_fee", p.CustomFee},
+	} {
+		if f.value < 0 {
+			fail(f.field, CodeNegative)
+		}
+	}
+
+	if len(o.Items) == 0 {
+		fail("items", CodeRequired)
+	}
+	goodsTotal := 0
+	for i, item := range o.Items {
+		field := func(name string) string { return fmt.Sprintf("items[%d].%s", i, name) }
+		if item.TrackNumber != o.TrackNumber {
+			fail(field("track_number"), CodeTrackNumberMismatch)
+		}
+		if item.Price < 0 {
+			fail(field("price"), CodeNegative)
+		}
+		if item.Sale < 0 || item.Sale > 100 {
+			fail(field("sale"), CodeOutOfRange)
+		} else if !totalPriceMatches(item) {
+			fail(field("total_price"), CodeTotalPriceMismatch)
+		}
+		goodsTotal += item.TotalPrice
+	}
+	if p.GoodsTotal != goodsTotal {
+		fail("payment.goods_total", CodeGoodsTotalMismatch)
+	}
+	if p.Amount != p.GoodsTotal+p.DeliveryCost+p.CustomFee {
+		fail("payment.amount", CodeAmountMismatch)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// totalPriceMatches reports whether the total price of the item is its price with the sale (in percents) applied.
+// The total price could be rounded either way.
+func totalPriceMatches(item Item) bool {
+	diff := item.TotalPrice*100 - item.Price*(100-item.Sale)
+	return diff > -100 && diff < 100
+}
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, file := range []string{"../model.json", "../model1.json", "../model2.json"} {
+		assert.NoError(t, testOrder(t, file).validate(now), "the sample %s must be valid", file)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(o *Order)
+		want   []string
+	}{
+		{
+			name:   "Empty fields",
+			modify: func(o *Order) { o.OrderUID, o.Payment.Transaction = "", "" },
+			want:   []string{"order_uid required", "payment.transaction required"},
+		},
+		{
+			name:   "Customer and delivery service",
+			modify: func(o *Order) { o.CustomerID, o.DeliveryService = "", "" },
+			want:   []string{"customer_id required", "delivery_service required"},
+		},
+		{
+			name:   "Contacts",
+			modify: func(o *Order) { o.Delivery.Email, o.Delivery.Phone = "wrong", "phone" },
+			want:   []string{"delivery.email invalid_format", "delivery.phone invalid_format"},
+		},
+		{
+			name:   "Currency and locale",
+			modify: func(o *Order) { o.Payment.Currency, o.Locale = "usd", "xx" },
+			want:   []string{"locale unknown_locale", "payment.currency unknown_currency"},
+		},
+		{
+			name:   "Zero date",
+			modify: func(o *Order) { o.DateCreated = time.Time{} },
+			want:   []string{"date_created required"},
+		},
+		{
+			name:   "Future date",
+			modify: func(o *Order) { o.DateCreated = now.Add(time.Hour) },
+			want:   []string{"date_created in_future"},
+		},
+		{
+			name:   "Clock skew",
+			modify: func(o *Order) { o.DateCreated = now.Add(time.Minute) },
+		},
+		{
+			name:   "No items",
+			modify: func(o *Order) { o.Items, o.Payment.GoodsTotal, o.Payment.Amount = nil, 0, o.Payment.DeliveryCost },
+			want:   []string{"items required"},
+		},
+		{
+			name:   "Goods total",
+			modify: func(o *Order) { o.Payment.GoodsTotal++; o.Payment.Amount++ },
+			want:   []string{"payment.goods_total goods_total_mismatch"},
+		},
+		{
+			name:   "Amount",
+			modify: func(o *Order) { o.Payment.CustomFee = 100 },
+			want:   []string{"payment.amount amount_mismatch"},
+		},
+		{
+			name:   "Negative",
+			modify: func(o *Order) { o.Payment.CustomFee = -100; o.Payment.Amount -= 100 },
+			want:   []string{"payment.custom_fee negative"},
+		},
+		{
+			name: "Item total price",
+			modify: func(o *Order) {
+				o.Items[0].TotalPrice = 453
+				o.Payment.GoodsTotal, o.Payment.Amount = 453, 453+o.Payment.DeliveryCost
+			},
+			want: []string{"items[0].total_price total_price_mismatch"},
+		},
+		{
+			name:   "Item total price rounded up",
+			modify: func(o *Order) { o.Items[0].TotalPrice++; o.Payment.GoodsTotal++; o.Payment.Amount++ },
+		},
+		{
+			name:   "Item sale",
+			modify: func(o *Order) { o.Items[0].Sale = 101 },
+			want:   []string{"items[0].sale out_of_range"},
+		},
+		{
+			name: "Item track number",
+			modify: func(o *Order) {
+				item := o.Items[0]
+				item.TrackNumber = "OTHER"
+				o.Items = append(o.Items, item)
+				o.Payment.GoodsTotal, o.Payment.Amount = 2*item.TotalPrice, o.Payment.Amount+item.TotalPrice
+			},
+			want: []string{"items[1].track_number track_number_mismatch"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			o := testOrder(t, "../model.json")
+			tc.modify(&o)
+			err := o.validate(now)
+			if len(tc.want) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			var errs ValidationErrors
+			require.True(t, errors.As(err, &errs))
+			got := make([]string, 0, len(errs))
+			for _, e := range errs {
+				assert.NotEmpty(t, e.Message)
+				got = append(got, e.Path+" "+e.Code)
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func testOrder(t *testing.T, file string) Order {
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	var o Order
+	require.NoError(t, json.Unmarshal(data, &o))
+	return o
+}
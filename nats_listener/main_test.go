@@ -0,0 +1,30 @@
+package nats_listener
+
+// The embedded nats-streaming-server is used to test the nats_listener package.
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	stand "github.com/nats-io/nats-streaming-server/server"
+)
+
+const testCluster = "test-cluster"
+
+var stanServerURL string
+
+func TestMain(m *testing.M) {
+	sOpts := stand.GetDefaultOptions()
+	sOpts.ID = testCluster
+	nOpts := stand.DefaultNatsServerOptions
+	nOpts.Port = -1 // random port
+	srv, err := stand.RunServerWithOpts(sOpts, &nOpts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stanServerURL = srv.ClientURL()
+	code := m.Run()
+	srv.Shutdown()
+	os.Exit(code)
+}
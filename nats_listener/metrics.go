@@ -0,0 +1,69 @@
+package nats_listener
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// listenerMetrics are the counters updated by the message handler.
+type listenerMetrics struct {
+	received    prometheus.Counter
+	redelivered prometheus.Counter
+	stored      prometheus.Counter
+	rejected    *prometheus.CounterVec
+	violations  *prometheus.CounterVec
+	storeErrors prometheus.Counter
+}
+
+func newListenerMetrics() listenerMetrics {
+	counter := func(name, help string) prometheus.Counter {
+		return prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "orderserver",
+			Subsystem: "nats",
+			Name:      name,
+			Help:      help,
+		})
+	}
+	return listenerMetrics{
+		received:    counter("messages_received_total", "The number of the received messages including the redelivered ones."),
+		redelivered: counter("messages_redelivered_total", "The number of the messages redelivered by the streaming server."),
+		stored:      counter("orders_stored_total", "The number of the stored orders."),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "orderserver",
+			Subsystem: "nats",
+			Name:      "orders_rejected_total",
+			Help:      "The number of the rejected orders by the stage they were rejected at.",
+		}, []string{"reason"}),
+		violations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "orderserver",
+			Subsystem: "nats",
+			Name:      "validation_errors_total",
+			Help:      "The number of the validation errors of the rejected orders by the error code.",
+		}, []string{"code"}),
+		storeErrors: counter("store_errors_total", "The number of the orders that could not be stored and are waiting for redelivery."),
+	}
+}
+
+// WithMetrics registers the metrics of the listener: the received, redelivered, stored and rejected orders
+// and the validation errors of the rejected orders.
+func WithMetrics(reg prometheus.Registerer) ListenerOpt {
+	return func(nl *NATSListener) error {
+		if reg == nil {
+			return errors.New("nil metrics registry")
+		}
+		for _, c := range []prometheus.Collector{
+			nl.metrics.received,
+			nl.metrics.redelivered,
+			nl.metrics.stored,
+			nl.metrics.rejected,
+			nl.metrics.violations,
+			nl.metrics.storeErrors,
+		} {
+			if err := reg.Register(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -1,36 +1,115 @@
 package nats_listener
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/nats-io/stan.go"
+	"github.com/vanamelnik/wildberries-L0/envelope"
+	"github.com/vanamelnik/wildberries-L0/ingest"
 	"github.com/vanamelnik/wildberries-L0/models"
-	"github.com/vanamelnik/wildberries-L0/storage"
 )
 
-// NATSListener is used for listening to the NATS streaming server.
-// When a message (order) from the given subject is received, it is stored in the storage provided.
-type NATSListener struct {
-	sc  stan.Conn
-	sub stan.Subscription
-	s   storage.Storage
-}
+const defaultAckWait = 30 * time.Second
+
+type (
+	// NATSListener is used for listening to the NATS streaming server.
+	// When a message (order) from the given subject is received, it is passed to the ingestion service provided
+	// that unwraps the order from the envelope, checks and stores it.
+	//
+	// The subscription works in manual acknowledgement mode: the message is acknowledged only after
+	// the order has been stored (or rejected as invalid). If the storage fails, the message is left
+	// unacknowledged and the streaming server redelivers it after the AckWait period.
+	// NB: the storage should return from Store only after the order is durably stored
+	// (e.g. postgres.Storage in postgres.ModeSync), otherwise the orders could be lost.
+	NATSListener struct {
+		sc  stan.Conn
+		sub stan.Subscription
+		svc *ingest.Service
+
+		natsURL           string
+		ackWait           time.Duration
+		deadLetterSubject string
+
+		// lost is set by the connection lost handler, it is a pointer since the listener is passed by value.
+		lost *connLost
+		// metrics are registered by WithMetrics.
+		metrics listenerMetrics
+
+		// ctx is passed to the ingestion service on every incoming message. It is canceled
+		// when the listener is closed, so the in-flight storing is interrupted.
+		ctx    context.Context
+		cancel context.CancelFunc
+	}
+
+	ListenerOpt func(nl *NATSListener) error
+
+	// connLost keeps the reason the connection to the streaming server has been lost.
+	connLost struct {
+		mu     sync.Mutex
+		reason error
+	}
+
+	// RejectedOrder is the envelope published to the dead-letter subject
+	// for every message rejected by the listener.
+	RejectedOrder struct {
+		Subject    string    `json:"subject"`     // the subject the message was received from
+		Sequence   uint64    `json:"sequence"`    // the sequence number of the original message
+		Timestamp  time.Time `json:"timestamp"`   // the time the original message was published
+		RejectedAt time.Time `json:"rejected_at"` // the time the message was rejected
+		// SchemaVersion, Producer and MessageID are taken from the envelope of the message,
+		// empty if the envelope could not be decoded.
+		SchemaVersion int      `json:"schema_version,omitempty"`
+		Producer      string   `json:"producer,omitempty"`
+		MessageID     string   `json:"message_id,omitempty"`
+		Stage         string   `json:"stage"` // the stage of processing the message was rejected at
+		Errors        []string `json:"errors"`
+		// Violations are the validation errors if the message is rejected at the validation stage.
+		Violations models.ValidationErrors `json:"violations,omitempty"`
+		Payload    string                  `json:"payload"` // the original message including the envelope
+	}
+)
 
 // New creates a new connection to the nats-streaming-server and registers a callback method that
-// processes incoming orders.
-func New(stanCluster, clientID, durableName, subject string, s storage.Storage) (NATSListener, error) {
-	sc, err := stan.Connect(stanCluster, clientID)
+// processes incoming orders. The storing of the orders is canceled when ctx is done or the listener is closed.
+func New(ctx context.Context, stanCluster, clientID, durableName, subject string, svc *ingest.Service, opts ...ListenerOpt) (NATSListener, error) {
+	nl := NATSListener{
+		svc:     svc,
+		natsURL: stan.DefaultNatsURL,
+		ackWait: defaultAckWait,
+		lost:    &connLost{},
+		metrics: newListenerMetrics(),
+	}
+	for _, opt := range opts {
+		if err := opt(&nl); err != nil {
+			return NATSListener{}, fmt.Errorf("natsListener: could not apply option: %w", err)
+		}
+	}
+	sc, err := stan.Connect(stanCluster, clientID, stan.NatsURL(nl.natsURL),
+		stan.SetConnectionLostHandler(func(_ stan.Conn, reason error) {
+			log.Printf("natsListener: ERR: connection to the streaming server is lost: %s", reason)
+			nl.lost.set(reason)
+		}),
+	)
 	if err != nil {
 		return NATSListener{}, err
 	}
-	nl := NATSListener{
-		sc: sc,
-		s:  s,
-	}
-	sub, err := sc.Subscribe(subject, nl.msgHandler, stan.DurableName(durableName))
+	nl.sc = sc
+	nl.ctx, nl.cancel = context.WithCancel(ctx)
+	sub, err := sc.Subscribe(subject, nl.msgHandler,
+		stan.DurableName(durableName),
+		stan.SetManualAckMode(),
+		stan.AckWait(nl.ackWait),
+	)
 	if err != nil {
+		nl.cancel()
+		sc.Close()
 		return NATSListener{}, err
 	}
 	nl.sub = sub
@@ -38,8 +117,43 @@ func New(stanCluster, clientID, durableName, subject string, s storage.Storage)
 	return nl, nil
 }
 
+// WithAckWait sets the time the streaming server waits for the acknowledgement before redelivering
+// the message. The minimal value allowed by the server is one second.
+func WithAckWait(d time.Duration) ListenerOpt {
+	return func(nl *NATSListener) error {
+		if d < time.Second {
+			return errors.New("ack wait must be at least one second")
+		}
+		nl.ackWait = d
+		return nil
+	}
+}
+
+// WithDeadLetterSubject makes the listener republish the rejected messages wrapped in
+// RejectedOrder envelope to the given subject. By default rejected messages are only logged.
+func WithDeadLetterSubject(subject string) ListenerOpt {
+	return func(nl *NATSListener) error {
+		if subject == "" {
+			return errors.New("empty dead-letter subject")
+		}
+		nl.deadLetterSubject = subject
+		return nil
+	}
+}
+
+// WithNATSURL sets the URL of the NATS server. The default is stan.DefaultNatsURL.
+func WithNATSURL(url string) ListenerOpt {
+	return func(nl *NATSListener) error {
+		nl.natsURL = url
+		return nil
+	}
+}
+
 // Close closes nats streaming subscription and connection.
+// NB: the durable subscription is not unsubscribed, so the unacknowledged messages
+// are redelivered after the restart.
 func (nl NATSListener) Close() (retErr error) {
+	nl.cancel()
 	if err := nl.sub.Close(); err != nil {
 		retErr = multierror.Append(retErr, err)
 	}
@@ -49,19 +163,116 @@ func (nl NATSListener) Close() (retErr error) {
 	return
 }
 
-// msgHandler is a callback function that sends all incoming orders to the storage.
+// CheckAlive returns an error if the connection to the streaming server is lost for good:
+// the streaming server did not respond to pings or replaced the client. The listener could not recover
+// and must be recreated.
+func (nl NATSListener) CheckAlive(ctx context.Context) error {
+	if reason := nl.lost.get(); reason != nil {
+		return fmt.Errorf("natsListener: connection to the streaming server is lost: %w", reason)
+	}
+	return nil
+}
+
+// CheckConnection returns an error if the listener is not connected to the NATS server at the moment,
+// e.g. it is reconnecting.
+func (nl NATSListener) CheckConnection(ctx context.Context) error {
+	if err := nl.CheckAlive(ctx); err != nil {
+		return err
+	}
+	if nc := nl.sc.NatsConn(); nc == nil || !nc.IsConnected() {
+		return errors.New("natsListener: not connected to the NATS server")
+	}
+	return nil
+}
+
+func (l *connLost) set(reason error) {
+	if reason == nil {
+		reason = errors.New("unknown reason")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reason = reason
+}
+
+func (l *connLost) get() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.reason
+}
+
+// msgHandler is a callback function that passes all incoming messages to the ingestion service.
+// The message is acknowledged if the order is stored or it could never be stored
+// (malformed envelope, invalid or rejected duplicate order). Otherwise the message will be redelivered.
+// The redelivered duplicates are acknowledged silently since they are most likely
+// the orders stored by the listener itself before the acknowledgement was lost.
 func (nl NATSListener) msgHandler(msg *stan.Msg) {
-	var order models.Order
-	if err := json.Unmarshal(msg.Data, &order); err != nil {
-		log.Printf("natsListener: ERR: order rejected: incorrect order type: %s", err)
+	nl.metrics.received.Inc()
+	if msg.Redelivered {
+		nl.metrics.redelivered.Inc()
+	}
+	res, err := nl.svc.Ingest(nl.ctx, msg.Data)
+	if err != nil {
+		var rejectErr *ingest.RejectError
+		if !errors.As(err, &rejectErr) {
+			log.Printf("natsListener: ERR: could not store order %q, waiting for redelivery: %s", res.OrderUID, err)
+			nl.metrics.storeErrors.Inc()
+			return
+		}
+		if rejectErr.Stage == ingest.StageDuplicate && msg.Redelivered {
+			log.Printf("natsListener: order %q is already stored", res.OrderUID)
+			nl.ack(msg)
+			return
+		}
+		nl.reject(msg, res.Envelope, rejectErr)
+		return
+	}
+	nl.metrics.stored.Inc()
+	nl.ack(msg)
+	log.Printf("natsListener: order %q received and stored, version %d", res.OrderUID, res.Version)
+}
+
+// reject logs and counts the rejected message, publishes it to the dead-letter subject if it is set
+// and acknowledges the message. If the message could not be published, it is left unacknowledged
+// to be redelivered. env is the envelope of the message, empty if it could not be decoded.
+func (nl NATSListener) reject(msg *stan.Msg, env envelope.Envelope, rejectErr *ingest.RejectError) {
+	log.Printf("natsListener: ERR: message #%d: %s", msg.Sequence, rejectErr)
+	nl.metrics.rejected.WithLabelValues(rejectErr.Stage).Inc()
+	for _, v := range rejectErr.Violations() {
+		nl.metrics.violations.WithLabelValues(v.Code).Inc()
+	}
+	if nl.deadLetterSubject == "" {
+		nl.ack(msg)
 		return
 	}
-	if err := order.Validate(); err != nil {
-		log.Printf("natsListener: ERR: order rejected: invalid order: %s", err)
+	rejected := RejectedOrder{
+		Subject:       msg.Subject,
+		Sequence:      msg.Sequence,
+		Timestamp:     time.Unix(0, msg.Timestamp).UTC(),
+		RejectedAt:    time.Now().UTC(),
+		SchemaVersion: env.SchemaVersion,
+		Producer:      env.Producer,
+		MessageID:     env.MessageID,
+		Stage:         rejectErr.Stage,
+		Errors:        rejectErr.Errors(),
+		Violations:    rejectErr.Violations(),
+		Payload:       string(msg.Data),
+	}
+	data, err := json.Marshal(rejected)
+	if err != nil {
+		log.Printf("natsListener: unreachable: could not marshal rejected order: %s", err)
+		return
+	}
+	if err := nl.sc.Publish(nl.deadLetterSubject, data); err != nil {
+		log.Printf("natsListener: ERR: could not publish message #%d to the dead-letter subject, waiting for redelivery: %s",
+			msg.Sequence, err)
 		return
 	}
-	if err := nl.s.Store(order.OrderUID, string(msg.Data)); err != nil {
-		log.Printf("natsListener: ERR: order rejected: %s", err)
+	nl.ack(msg)
+}
+
+// ack acknowledges the message and logs the error if any.
+func (nl NATSListener) ack(msg *stan.Msg) {
+	if err := msg.Ack(); err != nil {
+		log.Printf("natsListener: ERR: could not acknowledge message #%d: %s", msg.Sequence, err)
 	}
-	log.Printf("natsListener: order %q received and stored", order.OrderUID)
 }
@@ -0,0 +1,308 @@
+package nats_listener
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/stan.go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/wildberries-L0/envelope"
+	"github.com/vanamelnik/wildberries-L0/ingest"
+	"github.com/vanamelnik/wildberries-L0/internal/testorder"
+	"github.com/vanamelnik/wildberries-L0/models"
+	"github.com/vanamelnik/wildberries-L0/storage"
+	"github.com/vanamelnik/wildberries-L0/storage/inmem"
+)
+
+// flakyStorage is a storage.Storage that simulates the database outage:
+// Store and Upsert return an error while the storage is down.
+type flakyStorage struct {
+	*inmem.Cache
+	mu   sync.Mutex
+	down bool
+}
+
+var errDBDown = errors.New("database is down")
+
+func newFlakyStorage(t *testing.T) *flakyStorage {
+	c, err := inmem.NewCache()
+	require.NoError(t, err)
+	return &flakyStorage{Cache: c}
+}
+
+func (f *flakyStorage) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *flakyStorage) isDown() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.down
+}
+
+func (f *flakyStorage) Store(ctx context.Context, orderUID, jsonOrder string) error {
+	if f.isDown() {
+		return errDBDown
+	}
+	return f.Cache.Store(ctx, orderUID, jsonOrder)
+}
+
+func (f *flakyStorage) Upsert(ctx context.Context, orderUID, jsonOrder string) (int64, error) {
+	if f.isDown() {
+		return 0, errDBDown
+	}
+	return f.Cache.Upsert(ctx, orderUID, jsonOrder)
+}
+
+func newService(t *testing.T, s storage.Storage, opts ...ingest.ServiceOpt) *ingest.Service {
+	svc, err := ingest.New(s, opts...)
+	require.NoError(t, err)
+	return svc
+}
+
+func TestNoLossOnStorageFailure(t *testing.T) {
+	const numOrders = 10
+	ctx := context.Background()
+	db := newFlakyStorage(t)
+	cache, err := inmem.NewCache(inmem.WithPersistentStorage(ctx, db))
+	require.NoError(t, err)
+
+	const subject = "orders-no-loss"
+	nl, err := New(ctx, testCluster, "test-listener", "test-durable", subject, newService(t, cache),
+		WithNATSURL(stanServerURL),
+		WithAckWait(time.Second),
+	)
+	require.NoError(t, err)
+	defer nl.Close()
+
+	pub, err := stan.Connect(testCluster, "test-publisher", stan.NatsURL(stanServerURL))
+	require.NoError(t, err)
+	defer pub.Close()
+
+	db.setDown(true)
+	orders := testorder.Batch(t, numOrders)
+	for _, o := range orders {
+		require.NoError(t, pub.Publish(subject, o))
+	}
+	// let the listener receive the messages and fail to store them.
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, 0, db.Len())
+	for uid := range orders {
+		_, err := cache.Get(ctx, uid)
+		assert.ErrorIs(t, err, storage.ErrNotFound, "the order must be rolled back from the cache")
+	}
+
+	db.setDown(false)
+	require.Eventually(t, func() bool { return db.Len() == numOrders }, 10*time.Second, 100*time.Millisecond,
+		"all the orders must be redelivered and stored after the database is recovered")
+	for uid, o := range orders {
+		got, err := cache.Get(ctx, uid)
+		require.NoError(t, err)
+		assert.JSONEq(t, string(o), got)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	db := newFlakyStorage(t)
+	const (
+		subject    = "orders-dlq"
+		deadLetter = "orders-dlq.rejected"
+	)
+	nl, err := New(ctx, testCluster, "test-listener-dlq", "test-durable", subject, newService(t, db),
+		WithNATSURL(stanServerURL),
+		WithDeadLetterSubject(deadLetter),
+	)
+	require.NoError(t, err)
+	defer nl.Close()
+
+	pub, err := stan.Connect(testCluster, "test-publisher-dlq", stan.NatsURL(stanServerURL))
+	require.NoError(t, err)
+	defer pub.Close()
+	rejected := make(chan RejectedOrder, 10)
+	sub, err := pub.Subscribe(deadLetter, func(m *stan.Msg) {
+		var r RejectedOrder
+		if assert.NoError(t, json.Unmarshal(m.Data, &r)) {
+			rejected <- r
+		}
+	})
+	require.NoError(t, err)
+	defer sub.Close()
+
+	receive := func() RejectedOrder {
+		select {
+		case r := <-rejected:
+			return r
+		case <-time.After(5 * time.Second):
+			t.Fatal("no message in the dead-letter subject")
+		}
+		return RejectedOrder{}
+	}
+
+	t.Run("Decode error", func(t *testing.T) {
+		payload := `{"order_uid": 42}`
+		require.NoError(t, pub.Publish(subject, []byte(payload)))
+		r := receive()
+		assert.Equal(t, ingest.StageDecode, r.Stage)
+		assert.Equal(t, subject, r.Subject)
+		assert.Equal(t, payload, r.Payload)
+		assert.NotZero(t, r.Sequence)
+		assert.False(t, r.Timestamp.IsZero())
+		assert.Len(t, r.Errors, 1)
+	})
+	t.Run("Unsupported schema version", func(t *testing.T) {
+		payload := `{"schema_version": 42, "producer": "future", "message_id": "m1", "payload": {}}`
+		require.NoError(t, pub.Publish(subject, []byte(payload)))
+		r := receive()
+		assert.Equal(t, ingest.StageDecode, r.Stage)
+		assert.Equal(t, payload, r.Payload)
+		assert.Equal(t, 42, r.SchemaVersion)
+		assert.Equal(t, "future", r.Producer)
+		assert.Equal(t, "m1", r.MessageID, "the header of the envelope must be kept")
+	})
+	t.Run("Validation errors", func(t *testing.T) {
+		payload := `{"order_uid": "", "delivery": {"email": "wrong"}}`
+		require.NoError(t, pub.Publish(subject, []byte(payload)))
+		r := receive()
+		assert.Equal(t, ingest.StageValidate, r.Stage)
+		assert.Equal(t, payload, r.Payload)
+		assert.Greater(t, len(r.Errors), 1, "all the validation errors must be listed")
+		assert.Len(t, r.Violations, len(r.Errors))
+		assert.Contains(t, r.Violations, models.ValidationError{
+			Path:    "delivery.email",
+			Code:    models.CodeInvalidFormat,
+			Message: "invalid format",
+		})
+		assert.Equal(t, 2.0, testutil.ToFloat64(nl.metrics.violations.WithLabelValues(models.CodeInvalidFormat)),
+			"the email and the phone are invalid")
+	})
+	assert.Equal(t, 0, db.Len())
+	t.Run("Duplicate", func(t *testing.T) {
+		for uid, o := range testorder.Batch(t, 1) {
+			require.NoError(t, pub.Publish(subject, o))
+			require.NoError(t, pub.Publish(subject, o))
+			r := receive()
+			assert.Equal(t, ingest.StageDuplicate, r.Stage)
+			assert.Equal(t, string(o), r.Payload)
+			_, err := db.Get(ctx, uid)
+			assert.NoError(t, err, "the first order must be stored")
+		}
+	})
+}
+
+func TestEnvelope(t *testing.T) {
+	ctx := context.Background()
+	db := newFlakyStorage(t)
+	const (
+		subject    = "orders-envelope"
+		deadLetter = "orders-envelope.rejected"
+	)
+	nl, err := New(ctx, testCluster, "test-listener-envelope", "test-durable", subject, newService(t, db),
+		WithNATSURL(stanServerURL),
+		WithDeadLetterSubject(deadLetter),
+	)
+	require.NoError(t, err)
+	defer nl.Close()
+
+	pub, err := stan.Connect(testCluster, "test-publisher-envelope", stan.NatsURL(stanServerURL))
+	require.NoError(t, err)
+	defer pub.Close()
+	rejected := make(chan RejectedOrder, 1)
+	sub, err := pub.Subscribe(deadLetter, func(m *stan.Msg) {
+		var r RejectedOrder
+		if assert.NoError(t, json.Unmarshal(m.Data, &r)) {
+			rejected <- r
+		}
+	})
+	require.NoError(t, err)
+	defer sub.Close()
+
+	orders := testorder.Batch(t, 2)
+	bare := true
+	for uid, o := range orders {
+		msg := o
+		if !bare {
+			msg, err = envelope.New("test", o).Marshal()
+			require.NoError(t, err)
+		}
+		bare = false
+		require.NoError(t, pub.Publish(subject, msg))
+		require.Eventually(t, func() bool {
+			_, err := db.Get(ctx, uid)
+			return err == nil
+		}, 5*time.Second, 50*time.Millisecond, "the order must be stored")
+		got, err := db.Get(ctx, uid)
+		require.NoError(t, err)
+		assert.JSONEq(t, string(o), got, "the order must be stored without the envelope")
+	}
+
+	env := envelope.New("test", []byte(`{"order_uid": ""}`))
+	msg, err := env.Marshal()
+	require.NoError(t, err)
+	require.NoError(t, pub.Publish(subject, msg))
+	select {
+	case r := <-rejected:
+		assert.Equal(t, ingest.StageValidate, r.Stage)
+		assert.Equal(t, envelope.CurrentVersion, r.SchemaVersion)
+		assert.Equal(t, "test", r.Producer)
+		assert.Equal(t, env.MessageID, r.MessageID)
+		assert.Equal(t, string(msg), r.Payload)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message in the dead-letter subject")
+	}
+}
+
+func TestDuplicateReplace(t *testing.T) {
+	ctx := context.Background()
+	db := newFlakyStorage(t)
+	const subject = "orders-replace"
+	nl, err := New(ctx, testCluster, "test-listener-replace", "test-durable", subject,
+		newService(t, db, ingest.WithDuplicatePolicy(ingest.DuplicateReplace)),
+		WithNATSURL(stanServerURL),
+	)
+	require.NoError(t, err)
+	defer nl.Close()
+
+	pub, err := stan.Connect(testCluster, "test-publisher-replace", stan.NatsURL(stanServerURL))
+	require.NoError(t, err)
+	defer pub.Close()
+
+	for uid, o := range testorder.Batch(t, 1) {
+		require.NoError(t, pub.Publish(subject, o))
+		var order map[string]interface{}
+		require.NoError(t, json.Unmarshal(o, &order))
+		order["entry"] = "CORRECTED"
+		corrected, err := json.Marshal(order)
+		require.NoError(t, err)
+		require.NoError(t, pub.Publish(subject, corrected))
+
+		require.Eventually(t, func() bool {
+			o, err := db.GetRecord(ctx, uid)
+			return err == nil && o.Version == 2
+		}, 5*time.Second, 50*time.Millisecond, "the order must be replaced")
+		got, err := db.Get(ctx, uid)
+		require.NoError(t, err)
+		assert.JSONEq(t, string(corrected), got)
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	ctx := context.Background()
+	nl, err := New(ctx, testCluster, "test-listener-health", "test-durable", "orders-health", newService(t, newFlakyStorage(t)),
+		WithNATSURL(stanServerURL),
+	)
+	require.NoError(t, err)
+	assert.NoError(t, nl.CheckAlive(ctx))
+	assert.NoError(t, nl.CheckConnection(ctx))
+
+	require.NoError(t, nl.Close())
+	assert.Error(t, nl.CheckConnection(ctx), "the closed listener must not be ready")
+}
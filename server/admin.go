@@ -0,0 +1,138 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+// WithAdminToken enables the admin routes protected by the given bearer token.
+// The admin routes are not registered unless the token is set.
+func WithAdminToken(token string) ServerOpt {
+	return func(srv *Server) error {
+		if token == "" {
+			return errors.New("empty admin token")
+		}
+		srv.adminToken = token
+		return nil
+	}
+}
+
+// registerAdminRoutes registers the admin routes.
+func (srv *Server) registerAdminRoutes() {
+	admin := srv.router.PathPrefix("/admin").Subrouter()
+	admin.Use(srv.adminAuth)
+	admin.HandleFunc("/orders/{uid}", srv.deleteHandler).Methods(http.MethodDelete)
+	admin.HandleFunc("/orders/{uid}/erase", srv.eraseHandler).Methods(http.MethodPost)
+	admin.HandleFunc("/orders/{uid}/erasures", srv.erasuresHandler).Methods(http.MethodGet)
+}
+
+// adminAuth is a middleware that checks the admin bearer token.
+func (srv *Server) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !srv.authorized(r) {
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorized reports whether the request has the admin bearer token.
+func (srv *Server) authorized(r *http.Request) bool {
+	want := "Bearer " + srv.adminToken
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(want)) == 1
+}
+
+// deleteHandler removes the order.
+// path: DELETE /admin/orders/{orderUID}
+func (srv *Server) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	uid := mux.Vars(r)["uid"]
+	if err := srv.s.Delete(r.Context(), uid); err != nil {
+		log.Printf("server: admin: could not delete order %s: %s", uid, err)
+		storageError(w, uid, err)
+		return
+	}
+	log.Printf("server: admin: order %s deleted", uid)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// eraseRequest is the body of the erasure request.
+type eraseRequest struct {
+	Requester string `json:"requester"`
+	Reason    string `json:"reason"`
+}
+
+// eraseHandler erases the personal data of the order.
+// path: POST /admin/orders/{orderUID}/erase
+// body: {"requester": "...", "reason": "..."}
+func (srv *Server) eraseHandler(w http.ResponseWriter, r *http.Request) {
+	uid := mux.Vars(r)["uid"]
+	var req eraseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Incorrect request: %s", err), http.StatusBadRequest)
+		return
+	}
+	if req.Requester == "" || req.Reason == "" {
+		http.Error(w, "Requester and reason must be provided.", http.StatusBadRequest)
+		return
+	}
+	version, err := srv.s.ErasePII(r.Context(), uid, storage.Erasure{Requester: req.Requester, Reason: req.Reason})
+	if err != nil {
+		log.Printf("server: admin: could not erase order %s: %s", uid, err)
+		storageError(w, uid, err)
+		return
+	}
+	log.Printf("server: admin: personal data of order %s erased by %s", uid, req.Requester)
+	writeJSON(w, http.StatusOK, struct {
+		OrderUID string `json:"order_uid"`
+		Version  int64  `json:"version"`
+	}{uid, version})
+}
+
+// erasuresHandler shows the audit log of the erasures of the order.
+// path: GET /admin/orders/{orderUID}/erasures
+func (srv *Server) erasuresHandler(w http.ResponseWriter, r *http.Request) {
+	uid := mux.Vars(r)["uid"]
+	al, ok := srv.s.(storage.AuditLog)
+	if !ok {
+		http.Error(w, "Audit log is not supported by the storage.", http.StatusNotImplemented)
+		return
+	}
+	erasures, err := al.Erasures(r.Context(), uid)
+	if errors.Is(err, storage.ErrNoAuditLog) {
+		http.Error(w, "Audit log is not supported by the storage.", http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		log.Printf("server: admin: could not get erasures of order %s: %s", uid, err)
+		storageError(w, uid, err)
+		return
+	}
+	type erasure struct {
+		Requester string    `json:"requester"`
+		Reason    string    `json:"reason"`
+		ErasedAt  time.Time `json:"erased_at"`
+	}
+	resp := make([]erasure, 0, len(erasures))
+	for _, e := range erasures {
+		resp = append(resp, erasure{e.Requester, e.Reason, e.ErasedAt.UTC()})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// storageError writes the response corresponding to the storage error.
+func storageError(w http.ResponseWriter, uid string, err error) {
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, fmt.Sprintf("Order %s not found.", uid), http.StatusNotFound)
+		return
+	}
+	http.Error(w, "Something went wrong...", http.StatusInternalServerError)
+}
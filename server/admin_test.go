@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/wildberries-L0/internal/testorder"
+	"github.com/vanamelnik/wildberries-L0/models"
+	"github.com/vanamelnik/wildberries-L0/storage/inmem"
+)
+
+func TestAdmin(t *testing.T) {
+	ctx := context.Background()
+	c, err := inmem.NewCache()
+	require.NoError(t, err)
+	ts := newTestServer(t, c, WithAdminToken(testToken))
+	require.NoError(t, c.Store(ctx, "admin-1", string(testorder.New(t, "admin-1", nil))))
+	require.NoError(t, c.Store(ctx, "admin-2", string(testorder.New(t, "admin-2", nil))))
+	admin := func(t *testing.T, method, path, token, body string) (*http.Response, []byte) {
+		req, err := http.NewRequest(method, ts.URL+"/admin/orders/"+path, strings.NewReader(body))
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return do(t, req)
+	}
+	const eraseBody = `{"requester": "dpo", "reason": "customer request"}`
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		for _, token := range []string{"", "wrong"} {
+			resp, _ := admin(t, http.MethodDelete, "admin-1", token, "")
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+			resp, _ = admin(t, http.MethodPost, "admin-1/erase", token, eraseBody)
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+			resp, _ = admin(t, http.MethodGet, "admin-1/erasures", token, "")
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		}
+		_, err := c.Get(ctx, "admin-1")
+		assert.NoError(t, err, "the order must not be deleted")
+	})
+	t.Run("Not found", func(t *testing.T) {
+		resp, _ := admin(t, http.MethodDelete, "nihil", testToken, "")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		resp, _ = admin(t, http.MethodPost, "nihil/erase", testToken, eraseBody)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+	t.Run("Incorrect erasure request", func(t *testing.T) {
+		for _, body := range []string{`{"reason": "customer request"}`, `{"requester": "dpo"}`, `garbage`} {
+			resp, _ := admin(t, http.MethodPost, "admin-1/erase", testToken, body)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+		}
+	})
+	t.Run("Erase", func(t *testing.T) {
+		resp, body := admin(t, http.MethodPost, "admin-1/erase", testToken, eraseBody)
+		require.Equal(t, http.StatusOK, resp.StatusCode, "%s", body)
+		assert.JSONEq(t, `{"order_uid": "admin-1", "version": 2}`, string(body))
+		got, err := c.Get(ctx, "admin-1")
+		require.NoError(t, err)
+		var o models.Order
+		require.NoError(t, json.Unmarshal([]byte(got), &o))
+		assert.Empty(t, o.Delivery.Name)
+		assert.Empty(t, o.Delivery.Email)
+
+		resp, body = admin(t, http.MethodGet, "admin-1/erasures", testToken, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var erasures []struct {
+			Requester string `json:"requester"`
+			Reason    string `json:"reason"`
+			ErasedAt  string `json:"erased_at"`
+		}
+		require.NoError(t, json.Unmarshal(body, &erasures))
+		require.Len(t, erasures, 1)
+		assert.Equal(t, "dpo", erasures[0].Requester)
+		assert.Equal(t, "customer request", erasures[0].Reason)
+		assert.NotEmpty(t, erasures[0].ErasedAt)
+
+		resp, body = admin(t, http.MethodGet, "admin-2/erasures", testToken, "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.JSONEq(t, `[]`, string(body))
+	})
+	t.Run("Delete", func(t *testing.T) {
+		resp, _ := admin(t, http.MethodDelete, "admin-2", testToken, "")
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		_, err := c.Get(ctx, "admin-2")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/vanamelnik/wildberries-L0/models"
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+//go:embed openapi.yaml
+var openAPIDoc []byte
+
+// API error codes.
+const (
+	codeBadRequest       = "bad_request"
+	codeNotFound         = "not_found"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeInternal         = "internal"
+)
+
+type (
+	// apiError is the body of the API error response.
+	apiError struct {
+		Error apiErrorBody `json:"error"`
+	}
+
+	apiErrorBody struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		// Details lists the particular errors, e.g. every validation error of the order.
+		Details []string `json:"details,omitempty"`
+		// Violations are the validation errors of the rejected order.
+		Violations models.ValidationErrors `json:"violations,omitempty"`
+	}
+
+	// ordersPage is the body of the order list response.
+	ordersPage struct {
+		// the orders are returned as they are stored
+		Orders []json.RawMessage `json:"orders"`
+		Next   string            `json:"next,omitempty"`
+		Prev   string            `json:"prev,omitempty"`
+	}
+)
+
+// registerAPIRoutes registers the routes of the JSON API.
+func (srv *Server) registerAPIRoutes() {
+	api := srv.router.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/orders", srv.apiListHandler).Methods(http.MethodGet)
+	if srv.ingest != nil {
+		api.HandleFunc("/orders", srv.ingestHandler).Methods(http.MethodPost)
+	}
+	api.HandleFunc("/orders/{uid}", srv.apiOrderHandler).Methods(http.MethodGet)
+	api.HandleFunc("/customers/{customerID}/orders", srv.apiCustomerOrdersHandler).Methods(http.MethodGet)
+	api.HandleFunc("/openapi.yaml", openAPIHandler).Methods(http.MethodGet)
+	api.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, codeNotFound, "no such endpoint")
+	})
+	api.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, fmt.Sprintf("method %s is not allowed", r.Method))
+	})
+}
+
+// apiListHandler returns the page of the orders. The parameters are the same as for the index page.
+// path: GET /api/v1/orders
+func (srv *Server) apiListHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := listQuery(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	srv.writeOrdersPage(w, r, q)
+}
+
+// apiCustomerOrdersHandler returns the page of the orders of the customer.
+// The parameters are the same as for the order list; the customer parameter is ignored.
+// path: GET /api/v1/customers/{customerID}/orders
+func (srv *Server) apiCustomerOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := listQuery(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	q.Filter.CustomerID = mux.Vars(r)["customerID"]
+	srv.writeOrdersPage(w, r, q)
+}
+
+// writeOrdersPage lists the orders and writes the page as the API response.
+func (srv *Server) writeOrdersPage(w http.ResponseWriter, r *http.Request, q storage.ListQuery) {
+	page, err := srv.s.List(r.Context(), q)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidCursor) {
+			writeAPIError(w, http.StatusBadRequest, codeBadRequest, "invalid page cursor")
+			return
+		}
+		log.Printf("server: api: could not get records from the storage: %s", err)
+		writeAPIError(w, http.StatusInternalServerError, codeInternal, "internal error")
+		return
+	}
+	resp := ordersPage{
+		Orders: make([]json.RawMessage, 0, len(page.Orders)),
+		Next:   page.Next,
+		Prev:   page.Prev,
+	}
+	for _, o := range page.Orders {
+		resp.Orders = append(resp.Orders, json.RawMessage(o.JSONOrder))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// apiOrderHandler returns the stored order. The version of the order is returned in the ETag header.
+// path: GET /api/v1/orders/{orderUID}
+func (srv *Server) apiOrderHandler(w http.ResponseWriter, r *http.Request) {
+	uid := mux.Vars(r)["uid"]
+	o, err := srv.s.GetRecord(r.Context(), uid)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			writeAPIError(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("order %s not found", uid))
+			return
+		}
+		log.Printf("server: api: could not get order %s: %s", uid, err)
+		writeAPIError(w, http.StatusInternalServerError, codeInternal, "internal error")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatInt(o.Version, 10)))
+	if _, err := w.Write([]byte(o.JSONOrder)); err != nil {
+		log.Printf("server: api: could not write the response: %s", err)
+	}
+}
+
+// openAPIHandler serves the OpenAPI document of the API.
+// path: GET /api/v1/openapi.yaml
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	if _, err := w.Write(openAPIDoc); err != nil {
+		log.Printf("server: api: could not write the response: %s", err)
+	}
+}
+
+// writeAPIError writes the structured error response.
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, apiError{Error: apiErrorBody{Code: code, Message: message}})
+}
+
+// writeJSON writes the value as the JSON response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("server: could not write the response: %s", err)
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/wildberries-L0/storage"
+	"github.com/vanamelnik/wildberries-L0/storage/inmem"
+)
+
+// newTestServer starts the server over the given storage.
+func newTestServer(t *testing.T, s storage.Storage, opts ...ServerOpt) *httptest.Server {
+	srv, err := New("", s, opts...)
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv.Handler)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// do sends the request and returns the response with the read body.
+func do(t *testing.T, req *http.Request) (*http.Response, []byte) {
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, body
+}
+
+// assertAPIError checks that the response is the structured API error with the given status and code.
+func assertAPIError(t *testing.T, resp *http.Response, body []byte, status int, code string) apiErrorBody {
+	assert.Equal(t, status, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var e apiError
+	require.NoError(t, json.Unmarshal(body, &e), "the body must be the API error: %s", body)
+	assert.Equal(t, code, e.Error.Code)
+	assert.NotEmpty(t, e.Error.Message)
+	return e.Error
+}
+
+func TestAPI(t *testing.T) {
+	c, err := inmem.NewCache()
+	require.NoError(t, err)
+	ts := newTestServer(t, c)
+	order, err := os.ReadFile("../model.json")
+	require.NoError(t, err)
+	const uid = "b563feb7b2b84b6test"
+	require.NoError(t, c.Store(context.Background(), uid, string(order)))
+	get := func(path string) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		require.NoError(t, err)
+		return do(t, req)
+	}
+
+	t.Run("Order", func(t *testing.T) {
+		resp, body := get("/api/v1/orders/" + uid)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		assert.Equal(t, `"1"`, resp.Header.Get("ETag"))
+		assert.JSONEq(t, string(order), string(body))
+	})
+	t.Run("Order not found", func(t *testing.T) {
+		resp, body := get("/api/v1/orders/nihil")
+		e := assertAPIError(t, resp, body, http.StatusNotFound, codeNotFound)
+		assert.Contains(t, e.Message, "nihil")
+	})
+	t.Run("List", func(t *testing.T) {
+		resp, body := get("/api/v1/orders?limit=10")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		var page ordersPage
+		require.NoError(t, json.Unmarshal(body, &page))
+		require.Len(t, page.Orders, 1)
+		assert.JSONEq(t, string(order), string(page.Orders[0]))
+		assert.Empty(t, page.Next)
+	})
+	t.Run("Invalid cursor", func(t *testing.T) {
+		resp, body := get("/api/v1/orders?after=garbage")
+		assertAPIError(t, resp, body, http.StatusBadRequest, codeBadRequest)
+	})
+	t.Run("Invalid parameter", func(t *testing.T) {
+		resp, body := get("/api/v1/orders?limit=-1")
+		assertAPIError(t, resp, body, http.StatusBadRequest, codeBadRequest)
+	})
+	t.Run("Unknown endpoint", func(t *testing.T) {
+		resp, body := get("/api/v1/nihil/1/2")
+		assertAPIError(t, resp, body, http.StatusNotFound, codeNotFound)
+	})
+	t.Run("Method not allowed", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/orders/"+uid, nil)
+		require.NoError(t, err)
+		resp, body := do(t, req)
+		e := assertAPIError(t, resp, body, http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		assert.Contains(t, e.Message, http.MethodDelete)
+	})
+	t.Run("OpenAPI document", func(t *testing.T) {
+		resp, body := get("/api/v1/openapi.yaml")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.True(t, strings.HasPrefix(string(body), "openapi:"))
+	})
+}
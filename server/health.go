@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// healthCheckTimeout limits the time of running all the health checks (a variable to be shortened in the tests).
+var healthCheckTimeout = 3 * time.Second
+
+// Health check statuses.
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+)
+
+type (
+	// HealthCheck is the check of a component the server depends on.
+	HealthCheck struct {
+		Name string
+		// Check returns nil if the component is healthy. It must return when ctx is done.
+		Check func(ctx context.Context) error
+		// Liveness is set if the server could not recover without restart when the check fails
+		// (e.g. the connection is closed for good). Such checks are reported by /healthz;
+		// all the checks are reported by /readyz.
+		Liveness bool
+	}
+
+	// healthReport is the body of the health endpoints response.
+	healthReport struct {
+		Status string        `json:"status"`
+		Checks []checkResult `json:"checks"`
+	}
+
+	checkResult struct {
+		Name       string  `json:"name"`
+		Status     string  `json:"status"`
+		Error      string  `json:"error,omitempty"`
+		DurationMs float64 `json:"duration_ms"`
+	}
+)
+
+// WithHealthChecks adds the checks reported by the health endpoints.
+func WithHealthChecks(checks ...HealthCheck) ServerOpt {
+	return func(srv *Server) error {
+		for _, c := range checks {
+			if c.Name == "" || c.Check == nil {
+				return errors.New("health check must have a name and a check function")
+			}
+		}
+		srv.healthChecks = append(srv.healthChecks, checks...)
+		return nil
+	}
+}
+
+// registerHealthRoutes registers the liveness and readiness endpoints.
+func (srv *Server) registerHealthRoutes() {
+	srv.router.HandleFunc("/healthz", srv.healthHandler(true)).Methods(http.MethodGet)
+	srv.router.HandleFunc("/readyz", srv.healthHandler(false)).Methods(http.MethodGet)
+}
+
+// healthHandler runs the liveness checks or, if livenessOnly is not set, all the checks.
+// The response status is 503 if any check fails.
+// path: GET /healthz, GET /readyz
+func (srv *Server) healthHandler(livenessOnly bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var checks []HealthCheck
+		for _, c := range srv.healthChecks {
+			if c.Liveness || !livenessOnly {
+				checks = append(checks, c)
+			}
+		}
+		report := runHealthChecks(r.Context(), checks)
+		status := http.StatusOK
+		if report.Status != statusOK {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, status, report)
+	}
+}
+
+// runHealthChecks runs the checks concurrently within healthCheckTimeout.
+func runHealthChecks(ctx context.Context, checks []HealthCheck) healthReport {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	report := healthReport{Status: statusOK, Checks: make([]checkResult, len(checks))}
+	wg := sync.WaitGroup{}
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c HealthCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := c.Check(ctx)
+			res := checkResult{
+				Name:       c.Name,
+				Status:     statusOK,
+				DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				res.Status, res.Error = statusFail, err.Error()
+			}
+			report.Checks[i] = res
+		}(i, c)
+	}
+	wg.Wait()
+	for _, res := range report.Checks {
+		if res.Status != statusOK {
+			report.Status = statusFail
+		}
+	}
+	return report
+}
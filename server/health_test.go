@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/wildberries-L0/storage/inmem"
+)
+
+func TestHealth(t *testing.T) {
+	defer func(d time.Duration) { healthCheckTimeout = d }(healthCheckTimeout)
+	healthCheckTimeout = 200 * time.Millisecond
+
+	// the checks wait for each other, so they pass only if they are run concurrently
+	started := make(chan struct{})
+	ok := HealthCheck{Name: "ok", Liveness: true, Check: func(ctx context.Context) error {
+		select {
+		case <-started:
+			return nil
+		case <-ctx.Done():
+			return errors.New("not run concurrently")
+		}
+	}}
+	alsoOK := HealthCheck{Name: "also_ok", Check: func(ctx context.Context) error {
+		close(started)
+		return nil
+	}}
+	failing := HealthCheck{Name: "failing", Check: func(ctx context.Context) error {
+		return errors.New("database is down")
+	}}
+	hanging := HealthCheck{Name: "hanging", Check: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+
+	c, err := inmem.NewCache()
+	require.NoError(t, err)
+	ts := newTestServer(t, c, WithHealthChecks(ok, alsoOK, failing, hanging))
+	get := func(t *testing.T, path string) (int, healthReport) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		require.NoError(t, err)
+		resp, body := do(t, req)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		var report healthReport
+		require.NoError(t, json.Unmarshal(body, &report), "%s", body)
+		return resp.StatusCode, report
+	}
+	statuses := func(report healthReport) map[string]string {
+		m := make(map[string]string, len(report.Checks))
+		for _, c := range report.Checks {
+			m[c.Name] = c.Status
+		}
+		return m
+	}
+
+	t.Run("Readiness", func(t *testing.T) {
+		start := time.Now()
+		status, report := get(t, "/readyz")
+		assert.Less(t, time.Since(start), time.Second, "the hanging check must be stopped by the timeout")
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, statusFail, report.Status)
+		assert.Equal(t, map[string]string{
+			"ok":      statusOK,
+			"also_ok": statusOK,
+			"failing": statusFail,
+			"hanging": statusFail,
+		}, statuses(report))
+		require.Len(t, report.Checks, 4)
+		assert.Equal(t, "database is down", report.Checks[2].Error)
+		assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks[3].Error)
+	})
+	t.Run("Liveness", func(t *testing.T) {
+		started = make(chan struct{})
+		close(started)
+		status, report := get(t, "/healthz")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, statusOK, report.Status)
+		assert.Equal(t, map[string]string{"ok": statusOK}, statuses(report), "only the liveness checks must be run")
+	})
+}
+
+func TestWithHealthChecks(t *testing.T) {
+	_, err := New("", nil, WithHealthChecks(HealthCheck{Name: "nil"}))
+	assert.Error(t, err)
+}
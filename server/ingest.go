@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+
+	"github.com/vanamelnik/wildberries-L0/ingest"
+	"github.com/vanamelnik/wildberries-L0/models"
+)
+
+const (
+	// maxIngestBodySize is the maximal size of the ingestion request body.
+	maxIngestBodySize = 32 << 20
+	// maxIngestLineSize is the maximal size of a single order in the NDJSON request.
+	maxIngestLineSize = 1 << 20
+
+	ndjsonContentType = "application/x-ndjson"
+)
+
+// API error codes of the ingestion endpoint.
+const (
+	codeUnauthorized         = "unauthorized"
+	codeInvalidOrder         = "invalid_order"
+	codeValidationFailed     = "validation_failed"
+	codeSchemaMismatch       = "schema_mismatch"
+	codeDuplicate            = "duplicate"
+	codeTooLarge             = "too_large"
+	codeUnsupportedMediaType = "unsupported_media_type"
+)
+
+// Statuses of the ingested orders.
+const (
+	statusStored   = "stored"
+	statusRejected = "rejected"
+	statusFailed   = "failed" // the order is valid but could not be stored, it may be sent again
+)
+
+type (
+	// ingestResult is the result of ingesting a single order.
+	ingestResult struct {
+		Line     int      `json:"line,omitempty"` // the line number in the NDJSON request
+		OrderUID string   `json:"order_uid,omitempty"`
+		Version  int64    `json:"version,omitempty"`
+		Status   string   `json:"status"`
+		Stage    string   `json:"stage,omitempty"` // the stage the order was rejected at
+		Errors   []string `json:"errors,omitempty"`
+		// Violations are the validation errors if the order is rejected at the schema or validation stage.
+		Violations models.ValidationErrors `json:"violations,omitempty"`
+		// Warnings are the unknown fields of the stored order if the ingestion service reports them.
+		Warnings models.ValidationErrors `json:"warnings,omitempty"`
+	}
+
+	// bulkIngestResult is the body of the NDJSON ingestion response.
+	bulkIngestResult struct {
+		Stored   int            `json:"stored"`
+		Rejected int            `json:"rejected"`
+		Failed   int            `json:"failed"`
+		Results  []ingestResult `json:"results"`
+		// Error is set if the request body could not be read to the end,
+		// the orders after the last reported line are not processed.
+		Error *apiErrorBody `json:"error,omitempty"`
+	}
+)
+
+// WithIngest enables the order ingestion endpoint POST /api/v1/orders that passes the orders
+// to the given ingestion service, the same one the NATS listener uses.
+// The endpoint is protected by the admin token, so the server could not be created
+// with the option unless the token is set (see WithAdminToken).
+func WithIngest(svc *ingest.Service) ServerOpt {
+	return func(srv *Server) error {
+		if svc == nil {
+			return errors.New("nil ingestion service")
+		}
+		srv.ingest = svc
+		return nil
+	}
+}
+
+// ingestHandler stores a single JSON order or, if the content type is application/x-ndjson,
+// every order from the request body, one per line.
+// path: POST /api/v1/orders
+func (srv *Server) ingestHandler(w http.ResponseWriter, r *http.Request) {
+	if !srv.authorized(r) {
+		writeAPIError(w, http.StatusUnauthorized, codeUnauthorized, "admin token required")
+		return
+	}
+	mediaType := ""
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(ct); err != nil {
+			writeAPIError(w, http.StatusBadRequest, codeBadRequest, "invalid content type")
+			return
+		}
+	}
+	body := http.MaxBytesReader(w, r.Body, maxIngestBodySize)
+	switch mediaType {
+	case "", "application/json":
+		srv.ingestSingle(w, r, body)
+	case ndjsonContentType:
+		srv.ingestBulk(w, r, body)
+	default:
+		writeAPIError(w, http.StatusUnsupportedMediaType, codeUnsupportedMediaType,
+			fmt.Sprintf("content type must be application/json or %s", ndjsonContentType))
+	}
+}
+
+// ingestSingle stores the order from the request body.
+func (srv *Server) ingestSingle(w http.ResponseWriter, r *http.Request, body io.Reader) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		status, e := readError(err)
+		writeJSON(w, status, apiError{Error: e})
+		return
+	}
+	res := srv.ingestOrder(r, data)
+	switch res.Status {
+	case statusStored:
+		writeJSON(w, http.StatusCreated, res)
+	case statusFailed:
+		writeAPIError(w, http.StatusInternalServerError, codeInternal, "internal error")
+	default:
+		status, code := http.StatusBadRequest, codeInvalidOrder
+		switch res.Stage {
+		case ingest.StageSchema:
+			status, code = http.StatusUnprocessableEntity, codeSchemaMismatch
+		case ingest.StageValidate:
+			status, code = http.StatusUnprocessableEntity, codeValidationFailed
+		case ingest.StageDuplicate:
+			status, code = http.StatusConflict, codeDuplicate
+		}
+		writeJSON(w, status, apiError{Error: apiErrorBody{
+			Code:       code,
+			Message:    fmt.Sprintf("order rejected at %s stage", res.Stage),
+			Details:    res.Errors,
+			Violations: res.Violations,
+		}})
+	}
+}
+
+// ingestBulk stores the orders from the NDJSON request body. Every order is stored independently,
+// the result is reported for every non-empty line.
+func (srv *Server) ingestBulk(w http.ResponseWriter, r *http.Request, body io.Reader) {
+	resp := bulkIngestResult{Results: make([]ingestResult, 0)}
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxIngestLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		res := srv.ingestOrder(r, data)
+		res.Line = line
+		switch res.Status {
+		case statusStored:
+			resp.Stored++
+		case statusRejected:
+			resp.Rejected++
+		default:
+			resp.Failed++
+		}
+		resp.Results = append(resp.Results, res)
+	}
+	status := http.StatusOK
+	if err := scanner.Err(); err != nil {
+		var e apiErrorBody
+		status, e = readError(err)
+		resp.Error = &e
+	}
+	log.Printf("server: api: bulk ingestion: %d orders stored, %d rejected, %d failed", resp.Stored, resp.Rejected, resp.Failed)
+	writeJSON(w, status, resp)
+}
+
+// readError describes the error of reading the request body: 413 if the body or the NDJSON line is too large,
+// otherwise 400 (e.g. the client has disconnected or the body is truncated).
+func readError(err error) (int, apiErrorBody) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return http.StatusRequestEntityTooLarge, apiErrorBody{
+			Code:    codeTooLarge,
+			Message: fmt.Sprintf("the request body exceeds %d bytes", maxBytesErr.Limit),
+		}
+	case errors.Is(err, bufio.ErrTooLong):
+		return http.StatusRequestEntityTooLarge, apiErrorBody{
+			Code:    codeTooLarge,
+			Message: fmt.Sprintf("the line exceeds %d bytes", maxIngestLineSize),
+		}
+	}
+	return http.StatusBadRequest, apiErrorBody{Code: codeBadRequest, Message: fmt.Sprintf("could not read the request body: %s", err)}
+}
+
+// ingestOrder passes the order to the ingestion service and reports the result.
+func (srv *Server) ingestOrder(r *http.Request, data []byte) ingestResult {
+	res := srv.ingestOrderResult(r, data)
+	srv.metrics.ingested.WithLabelValues(res.Status, res.Stage).Inc()
+	return res
+}
+
+func (srv *Server) ingestOrderResult(r *http.Request, data []byte) ingestResult {
+	res, err := srv.ingest.Ingest(r.Context(), data)
+	if err != nil {
+		var rejectErr *ingest.RejectError
+		if errors.As(err, &rejectErr) {
+			return ingestResult{
+				OrderUID:   rejectErr.OrderUID,
+				Status:     statusRejected,
+				Stage:      rejectErr.Stage,
+				Errors:     rejectErr.Errors(),
+				Violations: rejectErr.Violations(),
+			}
+		}
+		log.Printf("server: api: could not store order %q: %s", res.OrderUID, err)
+		return ingestResult{OrderUID: res.OrderUID, Status: statusFailed, Errors: []string{"internal error"}}
+	}
+	log.Printf("server: api: order %q received and stored, version %d", res.OrderUID, res.Version)
+	return ingestResult{OrderUID: res.OrderUID, Version: res.Version, Status: statusStored, Warnings: res.Warnings}
+}
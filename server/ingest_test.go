@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/wildberries-L0/envelope"
+	"github.com/vanamelnik/wildberries-L0/ingest"
+	"github.com/vanamelnik/wildberries-L0/internal/testorder"
+	"github.com/vanamelnik/wildberries-L0/models"
+	"github.com/vanamelnik/wildberries-L0/storage/inmem"
+)
+
+const testToken = "secret"
+
+// failingStorage could not store the order with the given UID.
+type failingStorage struct {
+	*inmem.Cache
+	failUID string
+}
+
+func (f failingStorage) Store(ctx context.Context, orderUID, jsonOrder string) error {
+	if orderUID == f.failUID {
+		return errors.New("database is down")
+	}
+	return f.Cache.Store(ctx, orderUID, jsonOrder)
+}
+
+// newIngestServer starts the server with the ingestion endpoint over the storage failing to store the order "failing".
+func newIngestServer(t *testing.T) (*httptest.Server, *inmem.Cache) {
+	c, err := inmem.NewCache()
+	require.NoError(t, err)
+	svc, err := ingest.New(failingStorage{Cache: c, failUID: "failing"})
+	require.NoError(t, err)
+	return newTestServer(t, c, WithAdminToken(testToken), WithIngest(svc)), c
+}
+
+// post sends the body to the ingestion endpoint.
+func post(t *testing.T, url, contentType, token string, body []byte) (*http.Response, []byte) {
+	req, err := http.NewRequest(http.MethodPost, url+"/api/v1/orders", bytes.NewReader(body))
+	require.NoError(t, err)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return do(t, req)
+}
+
+func TestWithIngest(t *testing.T) {
+	c, err := inmem.NewCache()
+	require.NoError(t, err)
+	svc, err := ingest.New(c)
+	require.NoError(t, err)
+	_, err = New("", c, WithIngest(svc))
+	assert.Error(t, err, "the ingestion endpoint must not be enabled without the admin token")
+}
+
+func TestIngestSingle(t *testing.T) {
+	ts, c := newIngestServer(t)
+	order := testorder.New(t, "api-1", nil)
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		for _, token := range []string{"", "wrong"} {
+			resp, body := post(t, ts.URL, "application/json", token, order)
+			assertAPIError(t, resp, body, http.StatusUnauthorized, codeUnauthorized)
+		}
+		_, err := c.Get(context.Background(), "api-1")
+		assert.Error(t, err, "the order must not be stored")
+	})
+	t.Run("Stored", func(t *testing.T) {
+		resp, body := post(t, ts.URL, "application/json; charset=utf-8", testToken, order)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		var res ingestResult
+		require.NoError(t, json.Unmarshal(body, &res))
+		assert.Equal(t, ingestResult{OrderUID: "api-1", Version: 1, Status: statusStored}, res)
+		got, err := c.Get(context.Background(), "api-1")
+		require.NoError(t, err)
+		assert.JSONEq(t, string(order), got)
+	})
+	t.Run("Envelope", func(t *testing.T) {
+		enveloped := testorder.New(t, "api-envelope", nil)
+		msg, err := envelope.New("test", enveloped).Marshal()
+		require.NoError(t, err)
+		resp, body := post(t, ts.URL, "application/json", testToken, msg)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode, "%s", body)
+		got, err := c.Get(context.Background(), "api-envelope")
+		require.NoError(t, err)
+		assert.JSONEq(t, string(enveloped), got, "the order must be stored without the envelope")
+	})
+	t.Run("Unsupported schema version", func(t *testing.T) {
+		resp, body := post(t, ts.URL, "application/json", testToken, []byte(`{"schema_version": 42, "payload": {}}`))
+		assertAPIError(t, resp, body, http.StatusBadRequest, codeInvalidOrder)
+	})
+	t.Run("Duplicate", func(t *testing.T) {
+		resp, body := post(t, ts.URL, "", testToken, order)
+		assertAPIError(t, resp, body, http.StatusConflict, codeDuplicate)
+	})
+	t.Run("Validation failed", func(t *testing.T) {
+		invalid := testorder.New(t, "api-2", map[string]interface{}{"locale": "xx"})
+		resp, body := post(t, ts.URL, "application/json", testToken, invalid)
+		e := assertAPIError(t, resp, body, http.StatusUnprocessableEntity, codeValidationFailed)
+		assert.Equal(t, models.ValidationErrors{models.NewValidationError("locale", models.CodeUnknownLocale)}, e.Violations)
+	})
+	t.Run("Invalid order", func(t *testing.T) {
+		resp, body := post(t, ts.URL, "application/json", testToken, []byte(`{"order_uid": 42}`))
+		assertAPIError(t, resp, body, http.StatusBadRequest, codeInvalidOrder)
+	})
+	t.Run("Storage failure", func(t *testing.T) {
+		resp, body := post(t, ts.URL, "application/json", testToken, testorder.New(t, "failing", nil))
+		assertAPIError(t, resp, body, http.StatusInternalServerError, codeInternal)
+	})
+	t.Run("Too large", func(t *testing.T) {
+		resp, body := post(t, ts.URL, "application/json", testToken, bytes.Repeat([]byte(" "), maxIngestBodySize+1))
+		assertAPIError(t, resp, body, http.StatusRequestEntityTooLarge, codeTooLarge)
+	})
+	t.Run("Truncated body", func(t *testing.T) {
+		conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = fmt.Fprintf(conn, "POST /api/v1/orders HTTP/1.1\r\nHost: test\r\nAuthorization: Bearer %s\r\n"+
+			"Content-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", testToken, len(order), order[:10])
+		require.NoError(t, err)
+		require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assertAPIError(t, resp, body, http.StatusBadRequest, codeBadRequest)
+	})
+	t.Run("Unsupported media type", func(t *testing.T) {
+		resp, body := post(t, ts.URL, "text/plain", testToken, order)
+		assertAPIError(t, resp, body, http.StatusUnsupportedMediaType, codeUnsupportedMediaType)
+	})
+}
+
+func TestIngestBulk(t *testing.T) {
+	ts, _ := newIngestServer(t)
+	ndjson := func(lines ...[]byte) []byte { return bytes.Join(lines, []byte("\n")) }
+	bulk := func(t *testing.T, body []byte) (int, bulkIngestResult) {
+		resp, data := post(t, ts.URL, ndjsonContentType, testToken, body)
+		var res bulkIngestResult
+		require.NoError(t, json.Unmarshal(data, &res), "%s", data)
+		return resp.StatusCode, res
+	}
+	lines := func(res bulkIngestResult) map[int]string {
+		statuses := make(map[int]string, len(res.Results))
+		for _, r := range res.Results {
+			statuses[r.Line] = r.Status
+		}
+		return statuses
+	}
+
+	t.Run("Mixed", func(t *testing.T) {
+		status, res := bulk(t, ndjson(
+			testorder.New(t, "bulk-1", nil),
+			[]byte(""), // the empty lines are skipped
+			[]byte(`{"order_uid": 42}`),
+			testorder.New(t, "failing", nil),
+			testorder.New(t, "bulk-1", nil),
+			testorder.New(t, "bulk-2", nil),
+		))
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, 2, res.Stored)
+		assert.Equal(t, 2, res.Rejected)
+		assert.Equal(t, 1, res.Failed)
+		assert.Nil(t, res.Error)
+		assert.Equal(t, map[int]string{
+			1: statusStored,
+			3: statusRejected,
+			4: statusFailed,
+			5: statusRejected,
+			6: statusStored,
+		}, lines(res))
+		assert.Equal(t, ingest.StageDuplicate, res.Results[3].Stage)
+	})
+	t.Run("Line too long", func(t *testing.T) {
+		long := []byte(`{"order_uid": "` + strings.Repeat("x", maxIngestLineSize) + `"}`)
+		status, res := bulk(t, ndjson(testorder.New(t, "bulk-3", nil), long, testorder.New(t, "bulk-4", nil)))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+		assert.Equal(t, 1, res.Stored)
+		assert.Equal(t, map[int]string{1: statusStored}, lines(res), "the lines before the long one must be reported")
+		require.NotNil(t, res.Error)
+		assert.Equal(t, codeTooLarge, res.Error.Code)
+	})
+}
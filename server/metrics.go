@@ -0,0 +1,100 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type (
+	// serverMetrics are the metrics updated by the instrumented handlers.
+	serverMetrics struct {
+		requests        *prometheus.CounterVec
+		requestDuration *prometheus.HistogramVec
+		ingested        *prometheus.CounterVec
+	}
+
+	// statusRecorder remembers the status of the response.
+	statusRecorder struct {
+		http.ResponseWriter
+		status int
+	}
+)
+
+func newServerMetrics() serverMetrics {
+	return serverMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "orderserver",
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "The number of the handled requests by the route, method and response status.",
+		}, []string{"route", "method", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "orderserver",
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "The latency of the request handlers by the route and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		ingested: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "orderserver",
+			Subsystem: "http",
+			Name:      "orders_ingested_total",
+			Help:      "The number of the orders posted to the API by the result and the rejection stage.",
+		}, []string{"status", "reason"}),
+	}
+}
+
+// WithMetrics registers the metrics of the server (the requests, their latency and the ingested orders)
+// and enables the /metrics endpoint serving the metrics gathered by g.
+func WithMetrics(reg prometheus.Registerer, g prometheus.Gatherer) ServerOpt {
+	return func(srv *Server) error {
+		if reg == nil || g == nil {
+			return errors.New("nil metrics registry")
+		}
+		for _, c := range []prometheus.Collector{srv.metrics.requests, srv.metrics.requestDuration, srv.metrics.ingested} {
+			if err := reg.Register(c); err != nil {
+				return err
+			}
+		}
+		srv.gatherer = g
+		return nil
+	}
+}
+
+// registerMetricsRoute registers the metrics endpoint and the middleware measuring the requests.
+func (srv *Server) registerMetricsRoute() {
+	if srv.gatherer == nil {
+		return
+	}
+	srv.router.Use(srv.instrument)
+	srv.router.Handle("/metrics", promhttp.HandlerFor(srv.gatherer, promhttp.HandlerOpts{})).Methods(http.MethodGet)
+}
+
+// instrument is a middleware that measures the requests by the route template, so the order UIDs
+// do not make the label values unbounded.
+func (srv *Server) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if cr := mux.CurrentRoute(r); cr != nil {
+			if tpl, err := cr.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		srv.metrics.requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		srv.metrics.requests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+	})
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
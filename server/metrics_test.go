@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/wildberries-L0/internal/testorder"
+	"github.com/vanamelnik/wildberries-L0/storage/inmem"
+)
+
+func TestMetrics(t *testing.T) {
+	c, err := inmem.NewCache()
+	require.NoError(t, err)
+	for _, uid := range []string{"metrics-1", "metrics-2"} {
+		require.NoError(t, c.Store(context.Background(), uid, string(testorder.New(t, uid, nil))))
+	}
+	reg := prometheus.NewRegistry()
+	srv, err := New("", c, WithMetrics(reg, reg))
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+	get := func(t *testing.T, path string) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		require.NoError(t, err)
+		return do(t, req)
+	}
+
+	for _, path := range []string{"/api/v1/orders/metrics-1", "/api/v1/orders/metrics-2", "/api/v1/orders/nihil"} {
+		get(t, path)
+	}
+	const route = "/api/v1/orders/{uid}"
+	assert.Equal(t, 2.0, testutil.ToFloat64(srv.metrics.requests.WithLabelValues(route, http.MethodGet, "200")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(srv.metrics.requests.WithLabelValues(route, http.MethodGet, "404")))
+	assert.Equal(t, 2, testutil.CollectAndCount(srv.metrics.requests),
+		"the requests must be labeled by the route template, not by the order UID")
+
+	resp, body := get(t, "/metrics")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `orderserver_http_requests_total{code="200",method="GET",route="/api/v1/orders/{uid}"} 2`,
+		"the metrics of the registry must be served: %s", body)
+	assert.NotContains(t, string(body), "metrics-1")
+}
+
+func TestWithMetricsNilRegistry(t *testing.T) {
+	_, err := New("", nil, WithMetrics(nil, prometheus.NewRegistry()))
+	assert.Error(t, err)
+}
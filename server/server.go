@@ -8,8 +8,13 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vanamelnik/wildberries-L0/ingest"
 	"github.com/vanamelnik/wildberries-L0/models"
 	"github.com/vanamelnik/wildberries-L0/storage"
 )
@@ -29,10 +34,22 @@ type Server struct {
 	orderTpl *template.Template
 	s        storage.Storage
 	router   *mux.Router
+
+	// adminToken is the bearer token required by the admin routes, see WithAdminToken.
+	adminToken string
+	// ingest is the ingestion service of the ingestion endpoint, see WithIngest.
+	ingest *ingest.Service
+	// healthChecks are reported by the health endpoints, see WithHealthChecks.
+	healthChecks []HealthCheck
+	// metrics are registered and gatherer serves /metrics if set, see WithMetrics.
+	metrics  serverMetrics
+	gatherer prometheus.Gatherer
 }
 
+type ServerOpt func(srv *Server) error
+
 // New creates a new server and registers the routes.
-func New(addr string, s storage.Storage) (*Server, error) {
+func New(addr string, s storage.Storage, opts ...ServerOpt) (*Server, error) {
 	mainTpl, err := template.New("index").Parse(indexFile)
 	if err != nil {
 		return nil, err
@@ -50,29 +67,181 @@ func New(addr string, s storage.Storage) (*Server, error) {
 		mainTpl:  mainTpl,
 		orderTpl: orderTpl,
 		s:        s,
+		router:   router,
+		metrics:  newServerMetrics(),
+	}
+	for _, opt := range opts {
+		if err := opt(&server); err != nil {
+			return nil, fmt.Errorf("server: could not apply option: %w", err)
+		}
+	}
+	if server.ingest != nil && server.adminToken == "" {
+		return nil, errors.New("server: the ingestion endpoint requires the admin token")
+	}
+	server.registerMetricsRoute()
+	server.registerHealthRoutes()
+	server.registerAPIRoutes()
+	if server.adminToken != "" {
+		server.registerAdminRoutes()
 	}
 	router.HandleFunc("/", server.indexHandler).Methods(http.MethodGet)
 	router.HandleFunc("/{uid}", server.orderHandler).Methods(http.MethodGet)
 	return &server, nil
 }
 
-// indexHandler shows the list of the orders in the storage.
-// path: GET /
+// indexPage is the data of the index template.
+type indexPage struct {
+	Orders []models.Order
+	// Params are the parameters of the request used to fill the search form.
+	Params url.Values
+	// SortLinks are the links to the first page sorted by the other fields with the same filter.
+	SortLinks []link
+	// Next and Prev are the links to the next and the previous pages, empty if there is no such page.
+	Next string
+	Prev string
+}
+
+// indexHandler shows the page of the sorted list of the orders in the storage.
+// path: GET /?sort={uid|date_created|amount}&order={asc|desc}&limit={n}&after={cursor}&before={cursor}
+// and the search parameters (see listFilter).
 func (srv *Server) indexHandler(w http.ResponseWriter, r *http.Request) {
-	records, err := srv.s.GetAll()
+	q, err := listQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Incorrect request: %s", err), http.StatusBadRequest)
+		return
+	}
+	page, err := srv.s.List(r.Context(), q)
 	if err != nil {
+		if errors.Is(err, storage.ErrInvalidCursor) {
+			http.Error(w, "Incorrect request: invalid page cursor.", http.StatusBadRequest)
+			return
+		}
 		log.Printf("server: could not get records from the storage: %s", err)
 		http.Error(w, "Something went wrong...", http.StatusInternalServerError)
 		return
 	}
-	orders := fillOrders(records)
-	if err := srv.mainTpl.Execute(w, orders); err != nil {
+	data := indexPage{
+		Orders:    fillOrders(page.Orders),
+		Params:    r.URL.Query(),
+		SortLinks: sortLinks(r.URL.Query()),
+	}
+	if page.Next != "" {
+		data.Next = pageLink(r.URL.Query(), "after", page.Next)
+	}
+	if page.Prev != "" {
+		data.Prev = pageLink(r.URL.Query(), "before", page.Prev)
+	}
+	if err := srv.mainTpl.Execute(w, data); err != nil {
 		log.Printf("server: could not execute the main template: %s", err)
 		http.Error(w, "Something went wrong...", http.StatusInternalServerError)
 		return
 	}
 }
 
+// listQuery parses the listing parameters of the request.
+func listQuery(params url.Values) (storage.ListQuery, error) {
+	q := storage.ListQuery{
+		SortBy: storage.SortField(params.Get("sort")),
+		After:  params.Get("after"),
+		Before: params.Get("before"),
+	}
+	filter, err := listFilter(params)
+	if err != nil {
+		return q, err
+	}
+	q.Filter = filter
+	switch params.Get("order") {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return q, fmt.Errorf("unknown order %q", params.Get("order"))
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return q, fmt.Errorf("incorrect limit %q", limit)
+		}
+		q.Limit = n
+	}
+	return q.Normalize()
+}
+
+// listFilter parses the search parameters of the request. The dates are expected in the form
+// of the date input (2006-01-02) or in RFC3339 format; the "to" date is inclusive.
+func listFilter(params url.Values) (storage.Filter, error) {
+	f := storage.Filter{
+		TrackNumber:     params.Get("track"),
+		CustomerID:      params.Get("customer"),
+		DeliveryService: params.Get("delivery_service"),
+		Email:           params.Get("email"),
+		Phone:           params.Get("phone"),
+		City:            params.Get("city"),
+		Bank:            params.Get("bank"),
+		Transaction:     params.Get("transaction"),
+		Brand:           params.Get("brand"),
+	}
+	parseDate := func(name string) (time.Time, bool, error) {
+		value := params.Get(name)
+		if value == "" {
+			return time.Time{}, false, nil
+		}
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t, false, nil
+		}
+		t, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("incorrect date %q", value)
+		}
+		return t, true, nil
+	}
+	from, _, err := parseDate("from")
+	if err != nil {
+		return f, err
+	}
+	to, wholeDay, err := parseDate("to")
+	if err != nil {
+		return f, err
+	}
+	if wholeDay {
+		to = to.AddDate(0, 0, 1)
+	}
+	f.CreatedFrom, f.CreatedTo = from, to
+	return f, nil
+}
+
+// link is a link on the page.
+type link struct {
+	Title string
+	URL   string
+}
+
+// sortLinks returns the links to the first page of the orders sorted by every field keeping the other parameters.
+func sortLinks(params url.Values) []link {
+	params.Del("after")
+	params.Del("before")
+	links := make([]link, 0, 4)
+	for _, l := range []struct{ title, sort, order string }{
+		{"UID", "uid", "asc"},
+		{"newest", "date_created", "desc"},
+		{"oldest", "date_created", "asc"},
+		{"amount", "amount", "desc"},
+	} {
+		params.Set("sort", l.sort)
+		params.Set("order", l.order)
+		links = append(links, link{Title: l.title, URL: "/?" + params.Encode()})
+	}
+	return links
+}
+
+// pageLink returns the link to the page with the given cursor keeping the other parameters.
+func pageLink(params url.Values, direction, cursor string) string {
+	params.Del("after")
+	params.Del("before")
+	params.Set(direction, cursor)
+	return "/?" + params.Encode()
+}
+
 // orderHandler shows the order provided in the path.
 // path: GET /{orderUID}
 func (srv *Server) orderHandler(w http.ResponseWriter, r *http.Request) {
@@ -82,7 +251,7 @@ func (srv *Server) orderHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Something went wrong...", http.StatusInternalServerError)
 		return
 	}
-	jsonOrder, err := srv.s.Get(uid)
+	jsonOrder, err := srv.s.Get(r.Context(), uid)
 	if err != nil {
 		log.Printf("server: could not get order %s: %s", uid, err)
 		if errors.Is(err, storage.ErrNotFound) {
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/wildberries-L0/internal/testorder"
+	"github.com/vanamelnik/wildberries-L0/storage"
+	"github.com/vanamelnik/wildberries-L0/storage/inmem"
+)
+
+func TestListQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    storage.ListQuery
+		wantErr bool
+	}{
+		{name: "Default", query: "", want: storage.ListQuery{SortBy: storage.SortByUID, Limit: storage.DefaultListLimit}},
+		{
+			name:  "Sorted",
+			query: "sort=amount&order=desc&limit=10&after=cursor",
+			want:  storage.ListQuery{SortBy: storage.SortByAmount, Desc: true, Limit: 10, After: "cursor"},
+		},
+		{name: "Limit is capped", query: "limit=100000", want: storage.ListQuery{SortBy: storage.SortByUID, Limit: storage.MaxListLimit}},
+		{name: "Unknown sort field", query: "sort=price", wantErr: true},
+		{name: "Unknown order", query: "order=random", wantErr: true},
+		{name: "Not a number limit", query: "limit=ten", wantErr: true},
+		{name: "Zero limit", query: "limit=0", wantErr: true},
+		{name: "Both cursors", query: "after=a&before=b", wantErr: true},
+		{name: "Incorrect date", query: "from=yesterday", wantErr: true},
+		{name: "Empty date range", query: "from=2021-11-30&to=2021-11-01", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			params, err := url.ParseQuery(tc.query)
+			require.NoError(t, err)
+			q, err := listQuery(params)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, q)
+		})
+	}
+}
+
+func TestListFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		wantFrom time.Time
+		wantTo   time.Time
+	}{
+		{
+			name:     "Plain dates",
+			from:     "2021-11-01",
+			to:       "2021-11-30",
+			wantFrom: time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC),
+			wantTo:   time.Date(2021, 12, 1, 0, 0, 0, 0, time.UTC), // the whole last day is included
+		},
+		{
+			name:     "RFC3339",
+			from:     "2021-11-01T10:00:00Z",
+			to:       "2021-11-30T12:30:00+03:00",
+			wantFrom: time.Date(2021, 11, 1, 10, 0, 0, 0, time.UTC),
+			wantTo:   time.Date(2021, 11, 30, 9, 30, 0, 0, time.UTC), // the exact time is not extended
+		},
+		{name: "No dates"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			params := url.Values{"city": {"Kiryat Mozkin"}}
+			if tc.from != "" {
+				params.Set("from", tc.from)
+			}
+			if tc.to != "" {
+				params.Set("to", tc.to)
+			}
+			f, err := listFilter(params)
+			require.NoError(t, err)
+			assert.Equal(t, "Kiryat Mozkin", f.City)
+			assert.True(t, tc.wantFrom.Equal(f.CreatedFrom), "from: %v", f.CreatedFrom)
+			assert.True(t, tc.wantTo.Equal(f.CreatedTo), "to: %v", f.CreatedTo)
+		})
+	}
+}
+
+func TestSortLinks(t *testing.T) {
+	links := sortLinks(url.Values{"city": {"Moscow"}, "after": {"cursor"}, "sort": {"amount"}, "limit": {"5"}})
+	assert.Equal(t, []link{
+		{Title: "UID", URL: "/?city=Moscow&limit=5&order=asc&sort=uid"},
+		{Title: "newest", URL: "/?city=Moscow&limit=5&order=desc&sort=date_created"},
+		{Title: "oldest", URL: "/?city=Moscow&limit=5&order=asc&sort=date_created"},
+		{Title: "amount", URL: "/?city=Moscow&limit=5&order=desc&sort=amount"},
+	}, links, "the links must lead to the first page keeping the filter and the limit")
+}
+
+func TestIndexPages(t *testing.T) {
+	c, err := inmem.NewCache()
+	require.NoError(t, err)
+	for i := 1; i <= 3; i++ {
+		uid := fmt.Sprintf("index-%d", i)
+		require.NoError(t, c.Store(context.Background(), uid, string(testorder.New(t, uid, nil))))
+	}
+	ts := newTestServer(t, c)
+	get := func(t *testing.T, path string) (int, string) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		require.NoError(t, err)
+		resp, body := do(t, req)
+		return resp.StatusCode, string(body)
+	}
+	linkRe := func(title string) *regexp.Regexp { return regexp.MustCompile(`href="([^"]*)">` + title) }
+	pageLinks := func(body string) (prev, next string) {
+		if m := linkRe("&larr; previous").FindStringSubmatch(body); m != nil {
+			prev = html.UnescapeString(m[1])
+		}
+		if m := linkRe("next &rarr;").FindStringSubmatch(body); m != nil {
+			next = html.UnescapeString(m[1])
+		}
+		return prev, next
+	}
+
+	for _, path := range []string{"/?limit=-1", "/?order=random", "/?after=garbage"} {
+		status, _ := get(t, path)
+		assert.Equal(t, http.StatusBadRequest, status, path)
+	}
+
+	status, body := get(t, "/?limit=2&city=Kiryat+Mozkin")
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "index-1")
+	assert.Contains(t, body, "index-2")
+	prev, next := pageLinks(body)
+	assert.Empty(t, prev, "the first page has no previous page")
+	require.NotEmpty(t, next)
+	params, err := url.ParseQuery(next[len("/?"):])
+	require.NoError(t, err)
+	assert.Equal(t, "2", params.Get("limit"), "the link must keep the parameters")
+	assert.Equal(t, "Kiryat Mozkin", params.Get("city"))
+
+	status, body = get(t, next)
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "index-3")
+	assert.NotContains(t, body, "index-1")
+	prev, next = pageLinks(body)
+	assert.Empty(t, next, "the last page has no next page")
+	require.NotEmpty(t, prev)
+
+	status, body = get(t, prev)
+	require.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "index-1")
+	assert.Contains(t, body, "index-2")
+	assert.NotContains(t, body, "index-3")
+}
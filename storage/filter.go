@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Filter describes the orders to be found. Only the orders matching all the set fields are found;
+// the string fields must match exactly, the zero values are ignored.
+type Filter struct {
+	TrackNumber     string
+	CustomerID      string
+	DeliveryService string
+	Email           string
+	Phone           string
+	City            string
+	Bank            string
+	Transaction     string
+	Brand           string    // the brand of any item of the order
+	CreatedFrom     time.Time // the orders created at or after the time
+	CreatedTo       time.Time // the orders created before the time
+}
+
+// IsEmpty reports whether no field of the filter is set.
+func (f Filter) IsEmpty() bool {
+	return f == Filter{}
+}
+
+// Match reports whether the JSON order matches the filter. The malformed orders match only the empty filter.
+// NB the storages that search the orders by other means must keep the same semantics.
+func (f Filter) Match(jsonOrder string) bool {
+	if f.IsEmpty() {
+		return true
+	}
+	var o struct {
+		TrackNumber     string `json:"track_number"`
+		CustomerID      string `json:"customer_id"`
+		DeliveryService string `json:"delivery_service"`
+		Delivery        struct {
+			Email string `json:"email"`
+			Phone string `json:"phone"`
+			City  string `json:"city"`
+		} `json:"delivery"`
+		Payment struct {
+			Bank        string `json:"bank"`
+			Transaction string `json:"transaction"`
+		} `json:"payment"`
+		Items []struct {
+			Brand string `json:"brand"`
+		} `json:"items"`
+		DateCreated string `json:"date_created"`
+	}
+	if err := json.Unmarshal([]byte(jsonOrder), &o); err != nil {
+		return false
+	}
+	matches := func(want, got string) bool { return want == "" || want == got }
+	if !matches(f.TrackNumber, o.TrackNumber) ||
+		!matches(f.CustomerID, o.CustomerID) ||
+		!matches(f.DeliveryService, o.DeliveryService) ||
+		!matches(f.Email, o.Delivery.Email) ||
+		!matches(f.Phone, o.Delivery.Phone) ||
+		!matches(f.City, o.Delivery.City) ||
+		!matches(f.Bank, o.Payment.Bank) ||
+		!matches(f.Transaction, o.Payment.Transaction) {
+		return false
+	}
+	if f.Brand != "" {
+		found := false
+		for _, item := range o.Items {
+			if item.Brand == f.Brand {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.CreatedFrom.IsZero() && f.CreatedTo.IsZero() {
+		return true
+	}
+	created, err := time.Parse(time.RFC3339Nano, o.DateCreated)
+	if err != nil {
+		// the orders without the creation date are out of any date range
+		return false
+	}
+	return (f.CreatedFrom.IsZero() || !created.Before(f.CreatedFrom)) &&
+		(f.CreatedTo.IsZero() || created.Before(f.CreatedTo))
+}
@@ -3,11 +3,17 @@ package inmem
 // inmem is in-memory cache that could be also used as independed repository.
 
 import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"reflect"
 	"sync"
+	"time"
 
 	"github.com/vanamelnik/wildberries-L0/storage"
+	"golang.org/x/sync/singleflight"
 )
 
 var _ storage.Storage = (*Cache)(nil)
@@ -16,10 +22,42 @@ type (
 	// Cache is an in-memory implementation of storage.Storage.
 	// It could be used as indepened repository or use another storage.Storage object
 	// for persistent storage.
+	//
+	// By default the cache is unbounded and holds all the orders of the persistent storage.
+	// If any eviction policy is set (see WithMaxEntries, WithMaxBytes, WithTTL), the least recently
+	// used orders are evicted and the orders missing in the cache are read from the persistent storage.
 	Cache struct {
 		mu                *sync.RWMutex
-		repository        map[string]string
+		repository        map[string]*entry
 		persistentStorage storage.Storage
+		// loads coalesces the concurrent reads of the same order from the persistent storage.
+		loads singleflight.Group
+
+		// lru is the list of the entries ordered by the time of the last access,
+		// the most recently used entry is at the front.
+		lru        *list.List
+		size       int // the total size of the entries in bytes
+		maxEntries int
+		maxBytes   int
+		ttl        time.Duration
+		now        func() time.Time
+
+		// warmUp is the warm-up strategy, see WithWarmUp.
+		warmUp    *WarmUp
+		warmUpCtx context.Context
+		// warmedUp is closed when the warm-up is finished.
+		warmedUp chan struct{}
+		// partial is set if the warm-up does not load all the orders.
+		partial bool
+
+		// erasures is the audit log of the standalone cache, see ErasePII.
+		erasures []storage.Erasure
+
+		// metrics are registered by WithMetrics.
+		metrics cacheMetrics
+
+		// inits are called after all the options are applied.
+		inits []func() error
 	}
 
 	StorageOpt func(s *Cache) error
@@ -29,70 +67,266 @@ type (
 func NewCache(opts ...StorageOpt) (*Cache, error) {
 	s := &Cache{
 		mu:         &sync.RWMutex{},
-		repository: make(map[string]string),
+		repository: make(map[string]*entry),
+		lru:        list.New(),
+		now:        time.Now,
+		warmedUp:   make(chan struct{}),
+		metrics:    newCacheMetrics(),
 	}
 	for _, opt := range opts {
 		if err := opt(s); err != nil {
 			return nil, fmt.Errorf("storage: cache: could not apply option: %w", err)
 		}
 	}
+	for _, init := range s.inits {
+		if err := init(); err != nil {
+			return nil, fmt.Errorf("storage: cache: %w", err)
+		}
+	}
+	if err := s.startWarmUp(); err != nil {
+		return nil, fmt.Errorf("storage: cache: warm-up: %w", err)
+	}
 
 	return s, nil
 }
 
 // WithPersistentStorage registers a given storage.Storage object as persistent storage.
-func WithPersistentStorage(ps storage.Storage) StorageOpt {
+// Unless another warm-up strategy is set by WithWarmUp, an unbounded cache loads all the orders
+// from the persistent storage using the given context, and a bounded one is filled on demand.
+func WithPersistentStorage(ctx context.Context, ps storage.Storage) StorageOpt {
 	return func(s *Cache) error {
-		orders, err := ps.GetAll()
-		if err != nil {
-			return err
-		}
-		for _, o := range orders {
-			s.Store(o.OrderUID, o.JSONOrder)
-		}
 		s.persistentStorage = ps
-		if len(orders) > 0 {
-			log.Printf("storage: inmem: %d record(s) successfully imported from the database", len(orders))
-		}
+		s.inits = append(s.inits, func() error {
+			if s.warmUp == nil && !s.bounded() {
+				s.warmUp = &WarmUp{PageSize: defaultWarmUpPageSize}
+				s.warmUpCtx = ctx
+			}
+			return nil
+		})
 		return nil
 	}
 }
 
 // Store implements storage.Storage interface.
-func (s *Cache) Store(orderUID, jsonOrder string) error {
+// If the persistent storage fails to store the order, the order is removed from the cache
+// and the error of the persistent storage is returned.
+func (s *Cache) Store(ctx context.Context, orderUID, jsonOrder string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	if _, ok := s.repository[orderUID]; ok {
+	if _, ok := s.lookup(orderUID); ok {
+		s.mu.Unlock()
 		return storage.ErrAlreadyExists
 	}
-	s.repository[orderUID] = jsonOrder
-	if s.persistentStorage != nil {
-		s.persistentStorage.Store(orderUID, jsonOrder)
+	e := s.add(storage.OrderDB{OrderUID: orderUID, JSONOrder: jsonOrder, Version: 1})
+	s.mu.Unlock()
+	if s.persistentStorage == nil {
+		return nil
+	}
+	if err := s.persistentStorage.Store(ctx, orderUID, jsonOrder); err != nil {
+		// rollback
+		s.mu.Lock()
+		if cur, ok := s.repository[orderUID]; ok && cur == e {
+			s.remove(e)
+		}
+		s.mu.Unlock()
+		return fmt.Errorf("storage: inmem: persistent storage: %w", err)
 	}
 	return nil
 }
 
 // Get implements storage.Storage interface.
-func (s *Cache) Get(orderUID string) (string, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	order, ok := s.repository[orderUID]
-	if !ok {
-		return "", storage.ErrNotFound
+// If the order is not in the cache, it is read from the persistent storage and cached.
+// The concurrent requests for the same missing order share a single read of the persistent storage.
+func (s *Cache) Get(ctx context.Context, orderUID string) (string, error) {
+	o, err := s.GetRecord(ctx, orderUID)
+	return o.JSONOrder, err
+}
+
+// GetRecord implements storage.Storage interface. See Get.
+func (s *Cache) GetRecord(ctx context.Context, orderUID string) (storage.OrderDB, error) {
+	if err := ctx.Err(); err != nil {
+		return storage.OrderDB{}, err
 	}
-	return order, nil
+	s.mu.Lock()
+	e, ok := s.lookup(orderUID)
+	s.mu.Unlock()
+	if ok {
+		s.metrics.hits.Inc()
+		return e.record(), nil
+	}
+	s.metrics.misses.Inc()
+	if s.persistentStorage == nil {
+		return storage.OrderDB{}, storage.ErrNotFound
+	}
+	resCh := s.loads.DoChan(orderUID, func() (interface{}, error) {
+		return s.load(orderUID)
+	})
+	select {
+	case res := <-resCh:
+		if res.Err != nil {
+			return storage.OrderDB{}, res.Err
+		}
+		return res.Val.(storage.OrderDB), nil
+	case <-ctx.Done():
+		return storage.OrderDB{}, ctx.Err()
+	}
+}
+
+// load reads the order from the persistent storage and puts it to the cache.
+// The read is shared by several callers, so it is not bound to the context of any of them
+// and is limited only by the timeouts of the persistent storage.
+func (s *Cache) load(orderUID string) (storage.OrderDB, error) {
+	o, err := s.persistentStorage.GetRecord(context.Background(), orderUID)
+	if err != nil {
+		return storage.OrderDB{}, err
+	}
+	s.mu.Lock()
+	if e, ok := s.repository[orderUID]; !ok || e.version < o.Version {
+		s.add(o)
+	}
+	s.mu.Unlock()
+	return o, nil
 }
 
 // GetAll implements storage.Storage interface.
-func (s *Cache) GetAll() ([]storage.OrderDB, error) {
+// If the cache does not hold all the orders, they are read from the persistent storage.
+func (s *Cache) GetAll(ctx context.Context) ([]storage.OrderDB, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.persistentStorage != nil && !s.complete() {
+		return s.persistentStorage.GetAll(ctx)
+	}
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	orders := make([]storage.OrderDB, 0, len(s.repository))
-	for uid, order := range s.repository {
-		orders = append(orders, storage.OrderDB{
-			OrderUID:  uid,
-			JSONOrder: order,
-		})
+	for _, e := range s.repository {
+		orders = append(orders, e.record())
 	}
 	return orders, nil
 }
+
+// Update implements storage.Storage interface.
+// If the persistent storage is registered, the version is checked by the persistent storage
+// and the stale order is evicted from the cache on conflict.
+func (s *Cache) Update(ctx context.Context, orderUID, jsonOrder string, version int64) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if s.persistentStorage != nil {
+		newVersion, err := s.persistentStorage.Update(ctx, orderUID, jsonOrder, version)
+		if err != nil {
+			s.evict(orderUID)
+			return 0, err
+		}
+		s.set(storage.OrderDB{OrderUID: orderUID, JSONOrder: jsonOrder, Version: newVersion})
+		return newVersion, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.lookup(orderUID)
+	if !ok {
+		return 0, storage.ErrNotFound
+	}
+	if e.version != version {
+		return 0, storage.ErrVersionConflict
+	}
+	s.add(storage.OrderDB{OrderUID: orderUID, JSONOrder: jsonOrder, Version: version + 1})
+	return version + 1, nil
+}
+
+// Upsert implements storage.Storage interface.
+func (s *Cache) Upsert(ctx context.Context, orderUID, jsonOrder string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if s.persistentStorage != nil {
+		version, err := s.persistentStorage.Upsert(ctx, orderUID, jsonOrder)
+		if err != nil {
+			s.evict(orderUID)
+			return 0, err
+		}
+		s.set(storage.OrderDB{OrderUID: orderUID, JSONOrder: jsonOrder, Version: version})
+		return version, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var version int64 = 1
+	if e, ok := s.lookup(orderUID); ok {
+		if sameJSON(e.jsonOrder, jsonOrder) {
+			return e.version, nil
+		}
+		version = e.version + 1
+	}
+	s.add(storage.OrderDB{OrderUID: orderUID, JSONOrder: jsonOrder, Version: version})
+	return version, nil
+}
+
+// sameJSON reports whether the JSON documents are equal regardless of the formatting and the order of the keys,
+// the same way the postgres storage compares JSONB.
+func sameJSON(a, b string) bool {
+	if a == b {
+		return true
+	}
+	var va, vb interface{}
+	if json.Unmarshal([]byte(a), &va) != nil || json.Unmarshal([]byte(b), &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// Delete implements storage.Storage interface.
+func (s *Cache) Delete(ctx context.Context, orderUID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.persistentStorage != nil {
+		err := s.persistentStorage.Delete(ctx, orderUID)
+		if err == nil || errors.Is(err, storage.ErrNotFound) {
+			s.evict(orderUID)
+		}
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.lookup(orderUID)
+	if !ok {
+		return storage.ErrNotFound
+	}
+	s.remove(e)
+	return nil
+}
+
+// set puts the order to the cache unless a newer version is already cached.
+func (s *Cache) set(o storage.OrderDB) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.repository[o.OrderUID]; !ok || e.version < o.Version {
+		s.add(o)
+	}
+}
+
+// evict removes the order from the cache.
+func (s *Cache) evict(orderUID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.repository[orderUID]; ok {
+		s.remove(e)
+	}
+}
+
+// Len returns the number of the orders in the cache.
+func (s *Cache) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.repository)
+}
+
+// Size returns the total size of the orders in the cache in bytes.
+func (s *Cache) Size() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.size
+}
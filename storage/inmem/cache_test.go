@@ -0,0 +1,629 @@
+package inmem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+// mockStorage is a storage.Storage used as a persistent storage in the tests.
+// It is backed by a standalone cache. Store returns storeErr if the error is set.
+// If getGate is set, GetRecord waits until the gate is closed.
+type mockStorage struct {
+	*Cache
+	mu       sync.Mutex
+	storeErr error
+	getCalls int
+	getGate  chan struct{}
+}
+
+func newMockStorage() *mockStorage {
+	c, err := NewCache()
+	if err != nil {
+		panic(err)
+	}
+	return &mockStorage{Cache: c}
+}
+
+func (m *mockStorage) Store(ctx context.Context, orderUID, jsonOrder string) error {
+	m.mu.Lock()
+	err := m.storeErr
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return m.Cache.Store(ctx, orderUID, jsonOrder)
+}
+
+func (m *mockStorage) GetRecord(ctx context.Context, orderUID string) (storage.OrderDB, error) {
+	m.mu.Lock()
+	m.getCalls++
+	gate := m.getGate
+	m.mu.Unlock()
+	if gate != nil {
+		<-gate
+	}
+	return m.Cache.GetRecord(ctx, orderUID)
+}
+
+// set changes the order bypassing the cache under test, like another instance of the app does.
+// Empty jsonOrder means deletion.
+func (m *mockStorage) set(orderUID, jsonOrder string) {
+	if jsonOrder == "" {
+		m.Cache.evict(orderUID)
+		return
+	}
+	if _, err := m.Cache.Upsert(context.Background(), orderUID, jsonOrder); err != nil {
+		panic(err)
+	}
+}
+
+// get returns the order bypassing the cache under test.
+func (m *mockStorage) get(orderUID string) string {
+	o, _ := m.Cache.Get(context.Background(), orderUID)
+	return o
+}
+
+func TestCacheStore(t *testing.T) {
+	ctx := context.Background()
+	ps := newMockStorage()
+	ps.set("imported", `{"id":0}`)
+	c, err := NewCache(WithPersistentStorage(ctx, ps))
+	require.NoError(t, err)
+
+	t.Run("Imported order", func(t *testing.T) {
+		got, err := c.Get(ctx, "imported")
+		require.NoError(t, err)
+		assert.Equal(t, `{"id":0}`, got)
+	})
+	t.Run("Store", func(t *testing.T) {
+		require.NoError(t, c.Store(ctx, "1", `{"id":1}`))
+		got, err := c.Get(ctx, "1")
+		require.NoError(t, err)
+		assert.Equal(t, `{"id":1}`, got)
+		assert.Equal(t, `{"id":1}`, ps.get("1"))
+	})
+	t.Run("Store duplicate", func(t *testing.T) {
+		assert.ErrorIs(t, c.Store(ctx, "1", `{"id":2}`), storage.ErrAlreadyExists)
+	})
+	t.Run("Rollback on persistent storage error", func(t *testing.T) {
+		errDB := errors.New("connection refused")
+		ps.storeErr = errDB
+		err := c.Store(ctx, "2", `{"id":2}`)
+		assert.ErrorIs(t, err, errDB)
+		_, err = c.Get(ctx, "2")
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+
+		ps.storeErr = nil
+		assert.NoError(t, c.Store(ctx, "2", `{"id":2}`), "the order must be stored after the storage is recovered")
+	})
+	t.Run("Canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		assert.ErrorIs(t, c.Store(ctx, "3", `{"id":3}`), context.Canceled)
+		_, err := c.Get(ctx, "1")
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestCacheEviction(t *testing.T) {
+	ctx := context.Background()
+	t.Run("Max entries", func(t *testing.T) {
+		c, err := NewCache(WithMaxEntries(2))
+		require.NoError(t, err)
+		require.NoError(t, c.Store(ctx, "1", "one"))
+		require.NoError(t, c.Store(ctx, "2", "two"))
+		_, err = c.Get(ctx, "1") // "2" becomes the least recently used
+		require.NoError(t, err)
+		require.NoError(t, c.Store(ctx, "3", "three"))
+		assert.Equal(t, 2, c.Len())
+		_, err = c.Get(ctx, "2")
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+		_, err = c.Get(ctx, "1")
+		assert.NoError(t, err)
+	})
+	t.Run("Max bytes", func(t *testing.T) {
+		c, err := NewCache(WithMaxBytes(10))
+		require.NoError(t, err)
+		require.NoError(t, c.Store(ctx, "1", "1234"))  // 5 bytes
+		require.NoError(t, c.Store(ctx, "2", "1234"))  // 10 bytes
+		require.NoError(t, c.Store(ctx, "3", "12345")) // 16 bytes, "1" and "2" are evicted
+		assert.Equal(t, 1, c.Len())
+		require.NoError(t, c.Store(ctx, "4", "12345678910")) // too large to be cached
+		assert.Equal(t, 1, c.Len())
+		_, err = c.Get(ctx, "3")
+		assert.NoError(t, err)
+	})
+	t.Run("TTL", func(t *testing.T) {
+		now := time.Now()
+		c, err := NewCache(WithTTL(time.Minute))
+		require.NoError(t, err)
+		c.now = func() time.Time { return now }
+		require.NoError(t, c.Store(ctx, "1", "one"))
+		require.NoError(t, c.Store(ctx, "2", "two"))
+		now = now.Add(40 * time.Second)
+		_, err = c.Get(ctx, "1") // the access prolongs the life of "1"
+		require.NoError(t, err)
+		now = now.Add(40 * time.Second)
+		_, err = c.Get(ctx, "2")
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+		_, err = c.Get(ctx, "1")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, c.Len())
+	})
+	t.Run("Read-through", func(t *testing.T) {
+		ps := newMockStorage()
+		for i := 0; i < 10; i++ {
+			ps.set(fmt.Sprint(i), fmt.Sprintf(`{"id":%d}`, i))
+		}
+		c, err := NewCache(WithPersistentStorage(ctx, ps), WithMaxEntries(5))
+		require.NoError(t, err)
+		assert.Equal(t, 0, c.Len(), "bounded cache must not import all the orders")
+		for i := 0; i < 10; i++ {
+			got, err := c.Get(ctx, fmt.Sprint(i))
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf(`{"id":%d}`, i), got)
+		}
+		assert.Equal(t, 5, c.Len())
+		all, err := c.GetAll(ctx)
+		require.NoError(t, err)
+		assert.Len(t, all, 10, "GetAll must read all the orders from the persistent storage")
+		_, err = c.Get(ctx, "nihil")
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+		assert.ErrorIs(t, c.Store(ctx, "0", `{"id":0}`), storage.ErrAlreadyExists,
+			"the evicted order must be reported as existing by the persistent storage")
+	})
+}
+
+func TestCacheReadThrough(t *testing.T) {
+	ctx := context.Background()
+	ps := newMockStorage()
+	c, err := NewCache(WithPersistentStorage(ctx, ps))
+	require.NoError(t, err)
+	// the order is written directly to the persistent storage (e.g. by another instance).
+	ps.set("1", `{"id":1}`)
+
+	t.Run("Concurrent misses are coalesced", func(t *testing.T) {
+		ps.getGate = make(chan struct{})
+		const n = 50
+		wg := sync.WaitGroup{}
+		wg.Add(n)
+		for i := 0; i < n; i++ {
+			go func() {
+				defer wg.Done()
+				got, err := c.Get(ctx, "1")
+				assert.NoError(t, err)
+				assert.Equal(t, `{"id":1}`, got)
+			}()
+		}
+		// let all the goroutines reach the persistent storage.
+		time.Sleep(100 * time.Millisecond)
+		close(ps.getGate)
+		wg.Wait()
+		assert.Equal(t, 1, ps.getCalls)
+	})
+	t.Run("The order is cached", func(t *testing.T) {
+		got, err := c.Get(ctx, "1")
+		require.NoError(t, err)
+		assert.Equal(t, `{"id":1}`, got)
+		assert.Equal(t, 1, ps.getCalls)
+	})
+	t.Run("Caller's context is respected while waiting", func(t *testing.T) {
+		ps.set("2", `{"id":2}`)
+		ps.getGate = make(chan struct{})
+		defer close(ps.getGate)
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		_, err := c.Get(ctx, "2")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+// mockNotifier is a storage.ChangeNotifier that passes the changes sent to the channel.
+type mockNotifier chan storage.Change
+
+func (n mockNotifier) Listen(ctx context.Context, fn func(storage.Change)) error {
+	for {
+		select {
+		case c := <-n:
+			fn(c)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func TestCacheChangeNotifier(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ps := newMockStorage()
+	notifier := make(mockNotifier)
+	c, err := NewCache(WithPersistentStorage(ctx, ps), WithChangeNotifier(ctx, notifier))
+	require.NoError(t, err)
+	require.NoError(t, c.Store(ctx, "1", `{"id":1}`))
+
+	// the changes made by another instance
+	ps.set("2", `{"id":2}`)
+	notifier <- storage.Change{Op: storage.OpInsert, OrderUID: "2"}
+	ps.set("1", `{"id":1,"updated":true}`)
+	notifier <- storage.Change{Op: storage.OpUpdate, OrderUID: "1", Version: 2}
+	ps.set("2", "")
+	notifier <- storage.Change{Op: storage.OpDelete, OrderUID: "2"}
+	ps.set("3", `{"id":3}`)
+	notifier <- storage.Change{Op: storage.OpInsert, OrderUID: "3"}
+	// the notifier is unbuffered, so the next change is received after the previous one is applied.
+	notifier <- storage.Change{Op: storage.OpDelete, OrderUID: "nihil"}
+
+	all, err := c.GetAll(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []storage.OrderDB{
+		{OrderUID: "1", JSONOrder: `{"id":1,"updated":true}`, Version: 2},
+		{OrderUID: "3", JSONOrder: `{"id":3}`, Version: 1},
+	}, all)
+
+	ps.set("4", `{"id":4}`)
+	notifier <- storage.Change{Op: storage.OpReset}
+	notifier <- storage.Change{Op: storage.OpDelete, OrderUID: "nihil"}
+	assert.Equal(t, 3, c.Len(), "all the orders must be reloaded after reset")
+
+	_, err = NewCache(WithChangeNotifier(ctx, notifier))
+	assert.Error(t, err, "change notifier without persistent storage must be rejected")
+}
+
+// mockStreamer is a persistent storage implementing storage.Streamer.
+// The orders are streamed in the descending order of the UIDs.
+// If streamGate is set, every page waits until the gate is closed. If err is set, Stream fails with it.
+type mockStreamer struct {
+	*mockStorage
+	streamGate chan struct{}
+	err        error
+}
+
+func (m mockStreamer) Stream(ctx context.Context, q storage.StreamQuery, fn func(page []storage.OrderDB) error) error {
+	if m.err != nil {
+		return m.err
+	}
+	all, _ := m.GetAll(ctx)
+	sort.Slice(all, func(i, j int) bool { return all[i].OrderUID > all[j].OrderUID })
+	if q.Limit > 0 && len(all) > q.Limit {
+		all = all[:q.Limit]
+	}
+	for len(all) > 0 {
+		if m.streamGate != nil {
+			<-m.streamGate
+		}
+		n := q.PageSize
+		if n > len(all) {
+			n = len(all)
+		}
+		if err := fn(all[:n]); err != nil {
+			return err
+		}
+		all = all[n:]
+	}
+	return nil
+}
+
+func TestCacheWarmUp(t *testing.T) {
+	ctx := context.Background()
+	ps := mockStreamer{mockStorage: newMockStorage()}
+	for i := 0; i < 10; i++ {
+		ps.set(fmt.Sprint(i), fmt.Sprintf(`{"id":%d}`, i))
+	}
+
+	t.Run("Paged", func(t *testing.T) {
+		progress := []int{}
+		c, err := NewCache(WithPersistentStorage(ctx, ps), WithWarmUp(ctx, WarmUp{
+			PageSize: 3,
+			Progress: func(loaded int) { progress = append(progress, loaded) },
+		}))
+		require.NoError(t, err)
+		assert.Equal(t, []int{3, 6, 9, 10}, progress)
+		assert.Equal(t, 10, c.Len())
+		assert.True(t, c.complete())
+	})
+	t.Run("Most recent", func(t *testing.T) {
+		c, err := NewCache(WithPersistentStorage(ctx, ps), WithWarmUp(ctx, WarmUp{PageSize: 3, Limit: 4}))
+		require.NoError(t, err)
+		assert.Equal(t, 4, c.Len())
+		all, err := c.GetAll(ctx)
+		require.NoError(t, err)
+		assert.Len(t, all, 10, "partially loaded cache must read all the orders from the persistent storage")
+	})
+	t.Run("Stop when full", func(t *testing.T) {
+		c, err := NewCache(WithPersistentStorage(ctx, ps), WithMaxEntries(5), WithWarmUp(ctx, WarmUp{PageSize: 3}))
+		require.NoError(t, err)
+		assert.Equal(t, 5, c.Len())
+		for _, uid := range []string{"9", "8", "7", "6", "5"} {
+			assert.Contains(t, c.repository, uid, "the most recent orders must be loaded")
+		}
+	})
+	t.Run("Background", func(t *testing.T) {
+		ps := ps
+		ps.streamGate = make(chan struct{})
+		c, err := NewCache(WithPersistentStorage(ctx, ps), WithWarmUp(ctx, WarmUp{PageSize: 5, Background: true}))
+		require.NoError(t, err)
+		assert.False(t, c.complete())
+		got, err := c.Get(ctx, "1")
+		require.NoError(t, err, "the order must be read from the persistent storage until the cache is warm")
+		assert.Equal(t, `{"id":1}`, got)
+		all, err := c.GetAll(ctx)
+		require.NoError(t, err)
+		assert.Len(t, all, 10)
+
+		close(ps.streamGate)
+		select {
+		case <-c.WarmedUp():
+		case <-time.After(time.Second):
+			t.Fatal("warm-up is not finished")
+		}
+		assert.True(t, c.complete())
+		assert.Equal(t, 10, c.Len())
+	})
+	t.Run("Failed", func(t *testing.T) {
+		ps := ps
+		ps.err = errors.New("database is down")
+		_, err := NewCache(WithPersistentStorage(ctx, ps), WithWarmUp(ctx, WarmUp{PageSize: 5}))
+		assert.Error(t, err, "the synchronous warm-up must fail")
+
+		c, err := NewCache(WithPersistentStorage(ctx, ps), WithWarmUp(ctx, WarmUp{PageSize: 5, Background: true}))
+		require.NoError(t, err)
+		select {
+		case <-c.WarmedUp():
+		case <-time.After(time.Second):
+			t.Fatal("the cache must be usable after the failed warm-up")
+		}
+		assert.NoError(t, c.CheckWarmUp(ctx))
+		assert.False(t, c.complete())
+		got, err := c.Get(ctx, "1")
+		require.NoError(t, err, "the order must be read from the persistent storage")
+		assert.Equal(t, `{"id":1}`, got)
+		all, err := c.GetAll(ctx)
+		require.NoError(t, err)
+		assert.Len(t, all, 10)
+	})
+}
+
+func TestCacheUpdate(t *testing.T) {
+	ctx := context.Background()
+	for name, opts := range map[string][]StorageOpt{
+		"Standalone":              nil,
+		"With persistent storage": {WithPersistentStorage(ctx, newMockStorage())},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, err := NewCache(opts...)
+			require.NoError(t, err)
+			require.NoError(t, c.Store(ctx, "1", `{"id":1}`))
+			o, err := c.GetRecord(ctx, "1")
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), o.Version)
+
+			v, err := c.Update(ctx, "1", `{"id":1,"v":2}`, o.Version)
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), v)
+			_, err = c.Update(ctx, "1", `{"id":1,"v":3}`, o.Version)
+			assert.ErrorIs(t, err, storage.ErrVersionConflict, "update of the stale version must be rejected")
+			_, err = c.Update(ctx, "nihil", `{}`, 1)
+			assert.ErrorIs(t, err, storage.ErrNotFound)
+
+			v, err = c.Upsert(ctx, "1", `{"id":1,"v":3}`)
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), v)
+			v, err = c.Upsert(ctx, "1", `{"v":3, "id":1}`)
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), v, "the version of the same order must not be changed")
+			v, err = c.Upsert(ctx, "2", `{"id":2}`)
+			require.NoError(t, err)
+			assert.Equal(t, int64(1), v)
+
+			o, err = c.GetRecord(ctx, "1")
+			require.NoError(t, err)
+			assert.Equal(t, storage.OrderDB{OrderUID: "1", JSONOrder: `{"id":1,"v":3}`, Version: 3}, o)
+		})
+	}
+	t.Run("Stale cache", func(t *testing.T) {
+		ps := newMockStorage()
+		c, err := NewCache(WithPersistentStorage(ctx, ps))
+		require.NoError(t, err)
+		require.NoError(t, c.Store(ctx, "1", `{"id":1}`))
+		ps.set("1", `{"id":1,"changed":"elsewhere"}`)
+		_, err = c.Update(ctx, "1", `{"id":1,"v":2}`, 1)
+		assert.ErrorIs(t, err, storage.ErrVersionConflict)
+		got, err := c.Get(ctx, "1")
+		require.NoError(t, err)
+		assert.Equal(t, `{"id":1,"changed":"elsewhere"}`, got, "the stale order must be evicted on conflict")
+	})
+}
+
+func TestCacheErasure(t *testing.T) {
+	ctx := context.Background()
+	const order = `{"order_uid":"1","delivery":{"name":"Test Testov","phone":"+9720000000","zip":"2639809",` +
+		`"city":"Kiryat Mozkin","address":"Ploshad Mira 15","email":"test@gmail.com"},"payment":{"amount":1817}}`
+	const erased = `{"order_uid":"1","delivery":{"name":"","phone":"","zip":"2639809",` +
+		`"city":"Kiryat Mozkin","address":"","email":""},"payment":{"amount":1817}}`
+	for name, opts := range map[string][]StorageOpt{
+		"Standalone":              nil,
+		"With persistent storage": {WithPersistentStorage(ctx, newMockStorage())},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, err := NewCache(opts...)
+			require.NoError(t, err)
+			require.NoError(t, c.Store(ctx, "1", order))
+
+			v, err := c.ErasePII(ctx, "1", storage.Erasure{Requester: "dpo", Reason: "customer request"})
+			require.NoError(t, err)
+			assert.Equal(t, int64(2), v)
+			got, err := c.Get(ctx, "1")
+			require.NoError(t, err)
+			assert.JSONEq(t, erased, got)
+			_, err = c.ErasePII(ctx, "nihil", storage.Erasure{})
+			assert.ErrorIs(t, err, storage.ErrNotFound)
+
+			erasures, err := c.Erasures(ctx, "1")
+			require.NoError(t, err)
+			require.Len(t, erasures, 1)
+			assert.Equal(t, "dpo", erasures[0].Requester)
+			assert.False(t, erasures[0].ErasedAt.IsZero())
+
+			require.NoError(t, c.Delete(ctx, "1"))
+			_, err = c.Get(ctx, "1")
+			assert.ErrorIs(t, err, storage.ErrNotFound)
+			assert.ErrorIs(t, c.Delete(ctx, "1"), storage.ErrNotFound)
+		})
+	}
+}
+
+func TestCacheList(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewCache()
+	require.NoError(t, err)
+	for i := 1; i <= 10; i++ {
+		// the amounts go in reverse order, the dates are not unique
+		o := fmt.Sprintf(`{"id":%d,"date_created":"2021-11-%02dT06:22:19Z","payment":{"amount":%d}}`, i, 1+i/2, 100-i)
+		require.NoError(t, c.Store(ctx, fmt.Sprintf("%02d", i), o))
+	}
+	uids := func(page storage.ListPage) []string {
+		res := make([]string, 0, len(page.Orders))
+		for _, o := range page.Orders {
+			res = append(res, o.OrderUID)
+		}
+		return res
+	}
+	tests := []struct {
+		name  string
+		query storage.ListQuery
+		pages [][]string
+	}{
+		{
+			name:  "By UID",
+			query: storage.ListQuery{Limit: 4},
+			pages: [][]string{{"01", "02", "03", "04"}, {"05", "06", "07", "08"}, {"09", "10"}},
+		},
+		{
+			name:  "By date descending",
+			query: storage.ListQuery{SortBy: storage.SortByDate, Desc: true, Limit: 3},
+			pages: [][]string{{"10", "09", "08"}, {"07", "06", "05"}, {"04", "03", "02"}, {"01"}},
+		},
+		{
+			name:  "By amount",
+			query: storage.ListQuery{SortBy: storage.SortByAmount, Limit: 5},
+			pages: [][]string{{"10", "09", "08", "07", "06"}, {"05", "04", "03", "02", "01"}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			q := tc.query
+			var page storage.ListPage
+			for i, want := range tc.pages {
+				page, err = c.List(ctx, q)
+				require.NoError(t, err)
+				assert.Equal(t, want, uids(page), "page %d", i)
+				assert.Equal(t, i > 0, page.Prev != "", "page %d prev", i)
+				q.After, q.Before = page.Next, ""
+			}
+			assert.Empty(t, page.Next, "no next page after the last one")
+			// go back to the first page
+			for i := len(tc.pages) - 2; i >= 0; i-- {
+				q.After, q.Before = "", page.Prev
+				page, err = c.List(ctx, q)
+				require.NoError(t, err)
+				assert.Equal(t, tc.pages[i], uids(page), "page %d backwards", i)
+				assert.NotEmpty(t, page.Next)
+			}
+			assert.Empty(t, page.Prev, "no previous page before the first one")
+		})
+	}
+	t.Run("Invalid cursor", func(t *testing.T) {
+		_, err := c.List(ctx, storage.ListQuery{After: "garbage"})
+		assert.ErrorIs(t, err, storage.ErrInvalidCursor)
+		page, err := c.List(ctx, storage.ListQuery{Limit: 1})
+		require.NoError(t, err)
+		_, err = c.List(ctx, storage.ListQuery{SortBy: storage.SortByAmount, After: page.Next})
+		assert.ErrorIs(t, err, storage.ErrInvalidCursor, "the cursor of another sort order must be rejected")
+	})
+}
+
+func TestCacheListFilter(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewCache()
+	require.NoError(t, err)
+	orders := map[string]string{
+		"1": `{"track_number":"T1","customer_id":"c1","delivery_service":"meest","date_created":"2021-11-01T10:00:00Z","delivery":{"city":"Moscow","email":"a@b.c"},"payment":{"bank":"alpha"},"items":[{"brand":"Vivienne Sabo"},{"brand":"Nike"}]}`,
+		"2": `{"track_number":"T2","customer_id":"c2","delivery_service":"meest","date_created":"2021-11-02T10:00:00Z","delivery":{"city":"Moscow","email":"d@e.f"},"payment":{"bank":"sber"},"items":[{"brand":"Nike"}]}`,
+		"3": `{"track_number":"T3","customer_id":"c1","delivery_service":"cdek","date_created":"2021-11-03T10:00:00Z","delivery":{"city":"Kazan","email":"a@b.c"},"payment":{"bank":"alpha"},"items":[]}`,
+		"4": `{"track_number":"T4"}`,
+	}
+	for uid, o := range orders {
+		require.NoError(t, c.Store(ctx, uid, o))
+	}
+	day := func(d int) time.Time { return time.Date(2021, 11, d, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name   string
+		filter storage.Filter
+		want   []string
+	}{
+		{"No filter", storage.Filter{}, []string{"1", "2", "3", "4"}},
+		{"Track number", storage.Filter{TrackNumber: "T2"}, []string{"2"}},
+		{"Customer", storage.Filter{CustomerID: "c1"}, []string{"1", "3"}},
+		{"Customer and delivery service", storage.Filter{CustomerID: "c1", DeliveryService: "meest"}, []string{"1"}},
+		{"City and bank", storage.Filter{City: "Moscow", Bank: "alpha"}, []string{"1"}},
+		{"Email", storage.Filter{Email: "a@b.c"}, []string{"1", "3"}},
+		{"Brand", storage.Filter{Brand: "Nike"}, []string{"1", "2"}},
+		{"Date range", storage.Filter{CreatedFrom: day(2), CreatedTo: day(3)}, []string{"2"}},
+		{"Date until", storage.Filter{CreatedTo: day(3)}, []string{"1", "2"}},
+		{"Nothing found", storage.Filter{Bank: "nihil"}, []string{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			page, err := c.List(ctx, storage.ListQuery{Filter: tc.filter})
+			require.NoError(t, err)
+			got := make([]string, 0, len(page.Orders))
+			for _, o := range page.Orders {
+				got = append(got, o.OrderUID)
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestCacheMetrics(t *testing.T) {
+	ctx := context.Background()
+	reg := prometheus.NewRegistry()
+	c, err := NewCache(WithMetrics(reg))
+	require.NoError(t, err)
+	_, err = NewCache(WithMetrics(nil))
+	assert.Error(t, err)
+
+	require.NoError(t, c.Store(ctx, "1", "order1"))
+	require.NoError(t, c.Store(ctx, "2", "order2"))
+	_, err = c.Get(ctx, "1")
+	require.NoError(t, err)
+	_, err = c.Get(ctx, "3")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.metrics.hits))
+	assert.Equal(t, 1.0, testutil.ToFloat64(c.metrics.misses))
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	gauges := make(map[string]float64)
+	for _, f := range families {
+		if f.GetType() == dto.MetricType_GAUGE {
+			gauges[f.GetName()] = f.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	assert.Equal(t, map[string]float64{"orderserver_cache_entries": 2, "orderserver_cache_bytes": 14}, gauges)
+
+	_, err = NewCache(WithMetrics(reg))
+	assert.Error(t, err, "the metrics must not be registered twice")
+}
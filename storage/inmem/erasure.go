@@ -0,0 +1,74 @@
+package inmem
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/vanamelnik/wildberries-L0/models"
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+var _ storage.AuditLog = (*Cache)(nil)
+
+// ErasePII implements storage.Storage interface.
+// If the persistent storage is registered, the order is erased and the erasure is recorded
+// by the persistent storage, then the erased order is read to the cache.
+func (s *Cache) ErasePII(ctx context.Context, orderUID string, e storage.Erasure) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if e.ErasedAt.IsZero() {
+		e.ErasedAt = s.now()
+	}
+	if s.persistentStorage != nil {
+		version, err := s.persistentStorage.ErasePII(ctx, orderUID, e)
+		// the order with the personal data must not stay in the cache in any case
+		s.evict(orderUID)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := s.load(orderUID); err != nil {
+			log.Printf("storage: inmem: ERR: could not read the erased order %s: %s", orderUID, err)
+		}
+		return version, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.lookup(orderUID)
+	if !ok {
+		return 0, storage.ErrNotFound
+	}
+	erased, err := models.ErasePII(cur.jsonOrder)
+	if err != nil {
+		return 0, fmt.Errorf("storage: inmem: could not erase order %s: %w", orderUID, err)
+	}
+	version := cur.version + 1
+	s.add(storage.OrderDB{OrderUID: orderUID, JSONOrder: erased, Version: version})
+	e.OrderUID = orderUID
+	s.erasures = append(s.erasures, e)
+	return version, nil
+}
+
+// Erasures implements storage.AuditLog interface. The audit log of the persistent storage is used
+// if it is registered and implements storage.AuditLog.
+func (s *Cache) Erasures(ctx context.Context, orderUID string) ([]storage.Erasure, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.persistentStorage != nil {
+		if al, ok := s.persistentStorage.(storage.AuditLog); ok {
+			return al.Erasures(ctx, orderUID)
+		}
+		return nil, fmt.Errorf("storage: inmem: persistent storage: %w", storage.ErrNoAuditLog)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	erasures := make([]storage.Erasure, 0)
+	for _, e := range s.erasures {
+		if e.OrderUID == orderUID {
+			erasures = append(erasures, e)
+		}
+	}
+	return erasures, nil
+}
@@ -0,0 +1,142 @@
+package inmem
+
+import (
+	"container/list"
+	"errors"
+	"time"
+
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+// entry is a cached order.
+type entry struct {
+	orderUID   string
+	jsonOrder  string
+	version    int64
+	keys       sortKeys
+	lastAccess time.Time
+	elem       *list.Element
+}
+
+// WithMaxEntries limits the number of the orders in the cache.
+func WithMaxEntries(n int) StorageOpt {
+	return func(s *Cache) error {
+		if n < 1 {
+			return errors.New("max entries must be positive")
+		}
+		s.maxEntries = n
+		return nil
+	}
+}
+
+// WithMaxBytes limits the total size of the orders (UIDs and JSON) in the cache.
+func WithMaxBytes(n int) StorageOpt {
+	return func(s *Cache) error {
+		if n < 1 {
+			return errors.New("max bytes must be positive")
+		}
+		s.maxBytes = n
+		return nil
+	}
+}
+
+// WithTTL makes the cache evict the orders that have not been accessed for the given period.
+func WithTTL(ttl time.Duration) StorageOpt {
+	return func(s *Cache) error {
+		if ttl <= 0 {
+			return errors.New("ttl must be positive")
+		}
+		s.ttl = ttl
+		return nil
+	}
+}
+
+// bounded reports whether any eviction policy is set.
+func (s *Cache) bounded() bool {
+	return s.maxEntries > 0 || s.maxBytes > 0 || s.ttl > 0
+}
+
+// complete reports whether the cache holds all the orders: no eviction policy is set
+// and all the orders have been loaded from the persistent storage.
+func (s *Cache) complete() bool {
+	// partial is set by the background warm-up before the cache is marked warm
+	return s.isWarm() && !s.bounded() && !s.partial
+}
+
+// size returns the size of the order in bytes.
+func (e *entry) size() int {
+	return len(e.orderUID) + len(e.jsonOrder)
+}
+
+// lookup returns the entry and marks it as recently used. Expired entries are removed.
+// NB the write lock must be held.
+func (s *Cache) lookup(orderUID string) (*entry, bool) {
+	s.removeExpired()
+	e, ok := s.repository[orderUID]
+	if !ok {
+		return nil, false
+	}
+	e.lastAccess = s.now()
+	s.lru.MoveToFront(e.elem)
+	return e, true
+}
+
+// add puts the order to the cache replacing the existing one and evicts the least recently used entries
+// if the limits are exceeded. The order that exceeds the size limit by itself is not cached and nil is returned.
+// NB the write lock must be held.
+func (s *Cache) add(o storage.OrderDB) *entry {
+	if old, ok := s.repository[o.OrderUID]; ok {
+		s.remove(old)
+	}
+	e := &entry{
+		orderUID:   o.OrderUID,
+		jsonOrder:  o.JSONOrder,
+		version:    o.Version,
+		keys:       newSortKeys(o.JSONOrder),
+		lastAccess: s.now(),
+	}
+	if s.maxBytes > 0 && e.size() > s.maxBytes {
+		return nil
+	}
+	e.elem = s.lru.PushFront(e)
+	s.repository[o.OrderUID] = e
+	s.size += e.size()
+	s.removeExpired()
+	for (s.maxEntries > 0 && len(s.repository) > s.maxEntries) || (s.maxBytes > 0 && s.size > s.maxBytes) {
+		s.remove(s.lru.Back().Value.(*entry))
+	}
+	return e
+}
+
+// remove deletes the entry from the cache.
+// NB the write lock must be held.
+func (s *Cache) remove(e *entry) {
+	s.lru.Remove(e.elem)
+	delete(s.repository, e.orderUID)
+	s.size -= e.size()
+}
+
+// removeExpired removes the entries that have not been accessed for the TTL period.
+// NB the write lock must be held.
+func (s *Cache) removeExpired() {
+	if s.ttl == 0 {
+		return
+	}
+	deadline := s.now().Add(-s.ttl)
+	for back := s.lru.Back(); back != nil; back = s.lru.Back() {
+		e := back.Value.(*entry)
+		if e.lastAccess.After(deadline) {
+			return
+		}
+		s.remove(e)
+	}
+}
+
+// record returns the cached order.
+func (e *entry) record() storage.OrderDB {
+	return storage.OrderDB{
+		OrderUID:  e.orderUID,
+		JSONOrder: e.jsonOrder,
+		Version:   e.version,
+	}
+}
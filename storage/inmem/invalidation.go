@@ -0,0 +1,88 @@
+package inmem
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+// WithChangeNotifier subscribes the cache to the changes of the persistent storage made by other
+// instances of the app, so the caches of all the instances converge. The subscription lasts until
+// the context is canceled. The persistent storage must be registered by WithPersistentStorage.
+func WithChangeNotifier(ctx context.Context, n storage.ChangeNotifier) StorageOpt {
+	return func(s *Cache) error {
+		s.inits = append(s.inits, func() error {
+			if s.persistentStorage == nil {
+				return errors.New("change notifier requires persistent storage")
+			}
+			go func() {
+				if err := n.Listen(ctx, func(c storage.Change) { s.applyChange(ctx, c) }); err != nil && ctx.Err() == nil {
+					log.Printf("storage: inmem: ERR: listening to the changes stopped: %s", err)
+				}
+			}()
+			return nil
+		})
+		return nil
+	}
+}
+
+// applyChange updates the cache according to the change made in the persistent storage.
+// The changed orders are evicted from the cache; if the cache holds all the orders,
+// the new and updated orders are read from the persistent storage.
+func (s *Cache) applyChange(ctx context.Context, c storage.Change) {
+	switch c.Op {
+	case storage.OpInsert, storage.OpUpdate, storage.OpDelete:
+		s.mu.Lock()
+		if e, ok := s.repository[c.OrderUID]; ok {
+			if c.Op != storage.OpDelete && (c.Op == storage.OpInsert || c.Version != 0 && e.version >= c.Version) {
+				// the order is already cached (e.g. changed by this instance)
+				s.mu.Unlock()
+				return
+			}
+			s.remove(e)
+		}
+		s.mu.Unlock()
+		if c.Op == storage.OpDelete || !s.complete() {
+			return
+		}
+		o, err := s.persistentStorage.GetRecord(ctx, c.OrderUID)
+		if err != nil {
+			log.Printf("storage: inmem: ERR: could not read the changed order %s: %s", c.OrderUID, err)
+			return
+		}
+		s.mu.Lock()
+		if e, ok := s.repository[c.OrderUID]; !ok || e.version < o.Version {
+			s.add(o)
+		}
+		s.mu.Unlock()
+	case storage.OpReset:
+		if err := s.reset(ctx); err != nil {
+			log.Printf("storage: inmem: ERR: could not reload the cache: %s", err)
+		}
+	default:
+		log.Printf("storage: inmem: unknown change %q of the order %s", c.Op, c.OrderUID)
+	}
+}
+
+// reset drops all the cached orders. If the cache holds all the orders, they are reloaded
+// from the persistent storage.
+func (s *Cache) reset(ctx context.Context) error {
+	var orders []storage.OrderDB
+	if s.complete() {
+		var err error
+		if orders, err = s.persistentStorage.GetAll(ctx); err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.repository = make(map[string]*entry, len(orders))
+	s.lru.Init()
+	s.size = 0
+	for _, o := range orders {
+		s.add(o)
+	}
+	return nil
+}
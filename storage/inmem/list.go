@@ -0,0 +1,129 @@
+package inmem
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+// sortKeys are the values of the order's fields used for sorting, see storage.SortField.
+type sortKeys struct {
+	created time.Time // zero if the order has no valid creation date
+	amount  int64
+}
+
+// newSortKeys extracts the sort keys from the JSON order. The missing or malformed fields are zero.
+func newSortKeys(jsonOrder string) sortKeys {
+	var o struct {
+		DateCreated string `json:"date_created"`
+		Payment     struct {
+			Amount int64 `json:"amount"`
+		} `json:"payment"`
+	}
+	_ = json.Unmarshal([]byte(jsonOrder), &o)
+	created, _ := time.Parse(time.RFC3339Nano, o.DateCreated)
+	return sortKeys{created: created, amount: o.Payment.Amount}
+}
+
+// List implements storage.Storage interface.
+// If the cache does not hold all the orders, the page is read from the persistent storage.
+// NB the cache has no indexes: every listing scans all the cached orders and decodes them if the filter is set.
+func (s *Cache) List(ctx context.Context, q storage.ListQuery) (storage.ListPage, error) {
+	if err := ctx.Err(); err != nil {
+		return storage.ListPage{}, err
+	}
+	if s.persistentStorage != nil && !s.complete() {
+		return s.persistentStorage.List(ctx, q)
+	}
+	q, err := q.Normalize()
+	if err != nil {
+		return storage.ListPage{}, err
+	}
+	cursor, err := q.Cursor()
+	if err != nil {
+		return storage.ListPage{}, err
+	}
+	var (
+		pivot *entry
+		// the orders are read in the reverse order for the previous page
+		desc = q.Desc != (q.Before != "")
+	)
+	if cursor != nil {
+		pivot = &entry{orderUID: cursor.UID}
+		switch q.SortBy {
+		case storage.SortByDate:
+			pivot.keys.created, _ = cursor.Date()
+		case storage.SortByAmount:
+			pivot.keys.amount, _ = strconv.ParseInt(cursor.Key, 10, 64)
+		}
+	}
+	// less reports whether the entry a goes before b in the reading order.
+	less := func(a, b *entry) bool {
+		c := compare(q.SortBy, a, b)
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	}
+
+	s.mu.RLock()
+	entries := make([]*entry, 0, len(s.repository))
+	for _, e := range s.repository {
+		if (pivot == nil || less(pivot, e)) && q.Filter.Match(e.jsonOrder) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return less(entries[i], entries[j]) })
+	if len(entries) > q.Limit+1 {
+		entries = entries[:q.Limit+1]
+	}
+	orders := make([]storage.OrderDB, 0, len(entries))
+	keys := make([]string, 0, len(entries))
+	for _, e := range entries {
+		orders = append(orders, e.record())
+		keys = append(keys, e.sortKey(q.SortBy))
+	}
+	s.mu.RUnlock()
+
+	return storage.NewListPage(q, orders, keys), nil
+}
+
+// compare compares the entries by the sort field and then by UID.
+func compare(field storage.SortField, a, b *entry) int {
+	switch field {
+	case storage.SortByDate:
+		switch {
+		case a.keys.created.Before(b.keys.created):
+			return -1
+		case a.keys.created.After(b.keys.created):
+			return 1
+		}
+	case storage.SortByAmount:
+		switch {
+		case a.keys.amount < b.keys.amount:
+			return -1
+		case a.keys.amount > b.keys.amount:
+			return 1
+		}
+	}
+	return strings.Compare(a.orderUID, b.orderUID)
+}
+
+// sortKey returns the cursor key of the entry for the sort field.
+func (e *entry) sortKey(field storage.SortField) string {
+	switch field {
+	case storage.SortByDate:
+		if e.keys.created.IsZero() {
+			return storage.MinDateKey
+		}
+		return e.keys.created.UTC().Format(time.RFC3339Nano)
+	case storage.SortByAmount:
+		return strconv.FormatInt(e.keys.amount, 10)
+	}
+	return e.orderUID
+}
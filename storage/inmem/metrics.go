@@ -0,0 +1,62 @@
+package inmem
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// cacheMetrics are the counters updated on reading; the size of the cache is reported
+// by the gauges created in WithMetrics.
+type cacheMetrics struct {
+	hits   prometheus.Counter
+	misses prometheus.Counter
+}
+
+func newCacheMetrics() cacheMetrics {
+	return cacheMetrics{
+		hits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "orderserver",
+			Subsystem: "cache",
+			Name:      "hits_total",
+			Help:      "The number of the orders read from the cache.",
+		}),
+		misses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "orderserver",
+			Subsystem: "cache",
+			Name:      "misses_total",
+			Help:      "The number of the orders missing in the cache.",
+		}),
+	}
+}
+
+// WithMetrics registers the metrics of the cache: the hits, the misses and the size of the cache.
+func WithMetrics(reg prometheus.Registerer) StorageOpt {
+	return func(s *Cache) error {
+		if reg == nil {
+			return errors.New("nil metrics registry")
+		}
+		collectors := []prometheus.Collector{
+			s.metrics.hits,
+			s.metrics.misses,
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Namespace: "orderserver",
+				Subsystem: "cache",
+				Name:      "entries",
+				Help:      "The number of the orders in the cache.",
+			}, func() float64 { return float64(s.Len()) }),
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Namespace: "orderserver",
+				Subsystem: "cache",
+				Name:      "bytes",
+				Help:      "The total size of the orders in the cache.",
+			}, func() float64 { return float64(s.Size()) }),
+		}
+		for _, c := range collectors {
+			if err := reg.Register(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
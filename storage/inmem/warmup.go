@@ -0,0 +1,161 @@
+package inmem
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+const defaultWarmUpPageSize = 1000
+
+// WarmUp describes how the cache is filled from the persistent storage at the start.
+type WarmUp struct {
+	// PageSize is the number of the orders read from the persistent storage at once.
+	PageSize int
+	// Limit is the maximal number of the most recent orders to be loaded, 0 means no limit.
+	Limit int
+	// Since makes the cache load only the orders created at or after the time.
+	Since time.Time
+	// Background makes the warm-up run in a separate goroutine, so NewCache returns immediately.
+	// Until the warm-up is finished, the orders missing in the cache are read from the persistent storage.
+	// If the background warm-up fails, the error is logged and the cache is considered warm
+	// but not holding all the orders, so it keeps reading the missing orders from the persistent storage.
+	Background bool
+	// Progress is called after every page with the total number of the loaded orders.
+	Progress func(loaded int)
+}
+
+// WithWarmUp sets the warm-up strategy. The context is used for reading the persistent storage.
+// If the strategy is not set, an unbounded cache loads all the orders synchronously and a bounded
+// one is filled on demand. NB The cache is considered to hold all the orders (and GetAll is served
+// from the cache) only if it is unbounded and the warm-up has no Limit and Since restrictions.
+func WithWarmUp(ctx context.Context, w WarmUp) StorageOpt {
+	return func(s *Cache) error {
+		if w.PageSize < 0 || w.Limit < 0 {
+			return errors.New("warm-up: negative page size or limit")
+		}
+		if w.PageSize == 0 {
+			w.PageSize = defaultWarmUpPageSize
+		}
+		s.warmUp = &w
+		s.warmUpCtx = ctx
+		s.partial = w.Limit > 0 || !w.Since.IsZero()
+		return nil
+	}
+}
+
+// WarmedUp returns the channel that is closed when the warm-up is finished.
+func (s *Cache) WarmedUp() <-chan struct{} {
+	return s.warmedUp
+}
+
+// CheckWarmUp returns an error until the warm-up is finished.
+func (s *Cache) CheckWarmUp(ctx context.Context) error {
+	if !s.isWarm() {
+		return errors.New("storage: cache: warm-up is in progress")
+	}
+	return nil
+}
+
+// isWarm reports whether the warm-up is finished.
+func (s *Cache) isWarm() bool {
+	select {
+	case <-s.warmedUp:
+		return true
+	default:
+		return false
+	}
+}
+
+// startWarmUp runs the warm-up according to the strategy set.
+func (s *Cache) startWarmUp() error {
+	if s.warmUp == nil {
+		close(s.warmedUp)
+		return nil
+	}
+	if s.persistentStorage == nil {
+		return errors.New("warm-up requires persistent storage")
+	}
+	if s.warmUp.Background {
+		go func() {
+			if err := s.runWarmUp(); err != nil {
+				log.Printf("storage: inmem: ERR: warm-up failed, the orders will be read on demand: %s", err)
+				s.partial = true
+				close(s.warmedUp)
+			}
+		}()
+		return nil
+	}
+	return s.runWarmUp()
+}
+
+// runWarmUp loads the orders from the persistent storage. The loading is stopped when the cache is full.
+// If the persistent storage does not implement storage.Streamer, all the orders are read at once
+// and the Since restriction is not applied. If the warm-up fails, the error is returned and the cache
+// is not marked warm.
+func (s *Cache) runWarmUp() error {
+	w := s.warmUp
+	start := time.Now()
+	loaded := 0
+	loadPage := func(page []storage.OrderDB) error {
+		s.mu.Lock()
+		full := false
+		for _, o := range page {
+			// the orders must not evict the more recent ones loaded before
+			if full = s.full(); full {
+				break
+			}
+			// the orders stored during the warm-up are not overwritten
+			if _, ok := s.repository[o.OrderUID]; !ok {
+				s.add(o)
+			}
+			loaded++
+		}
+		full = full || s.full()
+		s.mu.Unlock()
+		if w.Progress != nil {
+			w.Progress(loaded)
+		}
+		log.Printf("storage: inmem: warm-up: %d order(s) loaded", loaded)
+		if full {
+			return errCacheFull
+		}
+		return nil
+	}
+
+	var err error
+	if streamer, ok := s.persistentStorage.(storage.Streamer); ok {
+		err = streamer.Stream(s.warmUpCtx, storage.StreamQuery{
+			PageSize: w.PageSize,
+			Limit:    w.Limit,
+			Since:    w.Since,
+		}, loadPage)
+	} else {
+		var orders []storage.OrderDB
+		if orders, err = s.persistentStorage.GetAll(s.warmUpCtx); err == nil {
+			if w.Limit > 0 && len(orders) > w.Limit {
+				orders = orders[:w.Limit]
+			}
+			err = loadPage(orders)
+		}
+	}
+	if err != nil && !errors.Is(err, errCacheFull) {
+		return err
+	}
+	log.Printf("storage: inmem: warm-up finished: %d order(s) loaded in %v", loaded, time.Since(start))
+	close(s.warmedUp)
+	return nil
+}
+
+// errCacheFull stops the warm-up.
+var errCacheFull = errors.New("the cache is full")
+
+// full reports whether the cache reached its size limits.
+// NB the lock must be held.
+func (s *Cache) full() bool {
+	return (s.maxEntries > 0 && len(s.repository) >= s.maxEntries) ||
+		(s.maxBytes > 0 && s.size >= s.maxBytes)
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Listing limits.
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 1000
+)
+
+// Sort fields.
+const (
+	SortByUID    SortField = "uid"
+	SortByDate   SortField = "date_created"
+	SortByAmount SortField = "amount"
+)
+
+// MinDateKey is the sort key of the orders without the creation date.
+// Such orders go before all the others in ascending order.
+const MinDateKey = "-infinity"
+
+// ErrInvalidCursor is returned if the cursor is malformed or does not match the query.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type (
+	// SortField is the field the orders are sorted by. The orders with equal values are sorted by UID.
+	SortField string
+
+	// ListQuery describes the page of the orders to be listed.
+	// At most one of After and Before could be set; if none is set, the first page is listed.
+	ListQuery struct {
+		SortBy SortField // the default is SortByUID
+		Desc   bool
+		Limit  int    // the maximal number of the orders in the page, 0 means DefaultListLimit
+		After  string // the cursor of the last order of the previous page (ListPage.Next)
+		Before string // the cursor of the first order of the next page (ListPage.Prev)
+		// Filter limits the listed orders. The same filter must be used for all the pages.
+		Filter Filter
+	}
+
+	// ListPage is the page of the sorted orders.
+	ListPage struct {
+		Orders []OrderDB
+		// Next and Prev are the cursors for the next and the previous pages, empty if there is no such page.
+		Next string
+		Prev string
+	}
+
+	// Cursor points to the order in the sorted list of the orders.
+	Cursor struct {
+		SortBy SortField `json:"s"`
+		Desc   bool      `json:"d,omitempty"`
+		Key    string    `json:"k"` // the value of the sort field: RFC3339 time, decimal amount or UID
+		UID    string    `json:"u"`
+	}
+)
+
+// Normalize checks the query and sets the default values.
+func (q ListQuery) Normalize() (ListQuery, error) {
+	switch q.SortBy {
+	case "":
+		q.SortBy = SortByUID
+	case SortByUID, SortByDate, SortByAmount:
+	default:
+		return q, fmt.Errorf("unknown sort field %q", q.SortBy)
+	}
+	switch {
+	case q.Limit < 0:
+		return q, errors.New("negative limit")
+	case q.Limit == 0:
+		q.Limit = DefaultListLimit
+	case q.Limit > MaxListLimit:
+		q.Limit = MaxListLimit
+	}
+	if q.After != "" && q.Before != "" {
+		return q, errors.New("both after and before cursors are set")
+	}
+	if !q.Filter.CreatedFrom.IsZero() && !q.Filter.CreatedTo.IsZero() && !q.Filter.CreatedFrom.Before(q.Filter.CreatedTo) {
+		return q, errors.New("empty date range")
+	}
+	return q, nil
+}
+
+// Cursor decodes the cursor of the query (After or Before). nil is returned if no cursor is set.
+func (q ListQuery) Cursor() (*Cursor, error) {
+	token := q.After
+	if token == "" {
+		token = q.Before
+	}
+	if token == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if c.SortBy != q.SortBy || c.Desc != q.Desc {
+		return nil, fmt.Errorf("%w: the cursor belongs to another sort order", ErrInvalidCursor)
+	}
+	switch c.SortBy {
+	case SortByDate:
+		if _, err := c.Date(); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	case SortByAmount:
+		if _, err := strconv.ParseInt(c.Key, 10, 64); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+	return &c, nil
+}
+
+// Date returns the creation date of the cursor sorted by SortByDate.
+// Zero time is returned for MinDateKey.
+func (c Cursor) Date() (time.Time, error) {
+	if c.Key == MinDateKey {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, c.Key)
+}
+
+// String encodes the cursor to an opaque URL-safe token.
+func (c Cursor) String() string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		panic("unreachable: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// NewListPage makes the page from the orders read for the query along with their sort keys.
+// The orders must be read in the order of the query direction (reversed for the Before cursor),
+// and one extra order must be read if available to find out whether there are more orders.
+func NewListPage(q ListQuery, orders []OrderDB, keys []string) ListPage {
+	more := len(orders) > q.Limit
+	if more {
+		orders, keys = orders[:q.Limit], keys[:q.Limit]
+	}
+	if q.Before != "" {
+		for i, j := 0, len(orders)-1; i < j; i, j = i+1, j-1 {
+			orders[i], orders[j] = orders[j], orders[i]
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+	page := ListPage{Orders: orders}
+	if len(orders) == 0 {
+		return page
+	}
+	cursor := func(i int) string {
+		return Cursor{SortBy: q.SortBy, Desc: q.Desc, Key: keys[i], UID: orders[i].OrderUID}.String()
+	}
+	if more || q.Before != "" {
+		page.Next = cursor(len(orders) - 1)
+	}
+	if q.After != "" || (q.Before != "" && more) {
+		page.Prev = cursor(0)
+	}
+	return page
+}
@@ -0,0 +1,100 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vanamelnik/wildberries-L0/models"
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+var _ storage.AuditLog = (*Storage)(nil)
+
+// Delete implements storage.Storage interface.
+// NB Delete always works synchronously regardless of the storing mode, so in ModeAsync
+// the order could be not yet inserted by the storer.
+func (s *Storage) Delete(ctx context.Context, orderUID string) (err error) {
+	defer func(start time.Time) { s.metrics.observeWrite(opDelete, start, err) }(time.Now())
+	ctx, cancel := withTimeout(ctx, s.writeTimeout)
+	defer cancel()
+	res, err := s.db.ExecContext(ctx, `DELETE FROM orders WHERE uid = $1;`, orderUID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// ErasePII implements storage.Storage interface.
+// The order is erased and the audit log entry is recorded in the same transaction.
+func (s *Storage) ErasePII(ctx context.Context, orderUID string, e storage.Erasure) (_ int64, err error) {
+	defer func(start time.Time) { s.metrics.observeWrite(opErase, start, err) }(time.Now())
+	ctx, cancel := withTimeout(ctx, s.writeTimeout)
+	defer cancel()
+	if e.ErasedAt.IsZero() {
+		e.ErasedAt = time.Now()
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var jsonOrder string
+	err = tx.QueryRowContext(ctx, `SELECT json_order FROM orders WHERE uid = $1 FOR UPDATE;`, orderUID).Scan(&jsonOrder)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, storage.ErrNotFound
+		}
+		return 0, err
+	}
+	erased, err := models.ErasePII(jsonOrder)
+	if err != nil {
+		return 0, fmt.Errorf("storage: postgres: could not erase order %s: %w", orderUID, err)
+	}
+	var version int64
+	err = tx.QueryRowContext(ctx, `UPDATE orders SET json_order = $2, version = version + 1
+		WHERE uid = $1 RETURNING version;`, orderUID, erased).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.normalize(ctx, tx, orderUID, erased, version); err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO order_erasures (uid, requester, reason, erased_at) VALUES ($1, $2, $3, $4);`,
+		orderUID, e.Requester, e.Reason, e.ErasedAt)
+	if err != nil {
+		return 0, err
+	}
+	return version, tx.Commit()
+}
+
+// Erasures implements storage.AuditLog interface.
+func (s *Storage) Erasures(ctx context.Context, orderUID string) ([]storage.Erasure, error) {
+	ctx, cancel := withTimeout(ctx, s.readTimeout)
+	defer cancel()
+	rows, err := s.db.QueryContext(ctx, `SELECT requester, reason, erased_at FROM order_erasures
+		WHERE uid = $1 ORDER BY id;`, orderUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	erasures := make([]storage.Erasure, 0)
+	for rows.Next() {
+		e := storage.Erasure{OrderUID: orderUID}
+		if err := rows.Scan(&e.Requester, &e.Reason, &e.ErasedAt); err != nil {
+			return nil, err
+		}
+		erasures = append(erasures, e)
+	}
+	return erasures, rows.Err()
+}
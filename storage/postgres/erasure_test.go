@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+func TestErasure(t *testing.T) {
+	defer cleanOrdersTable(t)
+	ctx := context.Background()
+	_, err := pgMockStorage.Upsert(ctx, "erase-1",
+		`{"delivery":{"name":"Test Testov","phone":"+9720000000","city":"Kiryat Mozkin","email":"test@gmail.com"},"payment":{"amount":1817}}`)
+	require.NoError(t, err)
+	t.Run("Erase PII", func(t *testing.T) {
+		v, err := pgMockStorage.ErasePII(ctx, "erase-1", storage.Erasure{Requester: "dpo", Reason: "customer request"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), v)
+		got, err := pgMockStorage.Get(ctx, "erase-1")
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"delivery":{"name":"","phone":"","city":"Kiryat Mozkin","email":""},"payment":{"amount":1817}}`, got)
+
+		erasures, err := pgMockStorage.Erasures(ctx, "erase-1")
+		require.NoError(t, err)
+		require.Len(t, erasures, 1)
+		assert.Equal(t, "dpo", erasures[0].Requester)
+		assert.Equal(t, "customer request", erasures[0].Reason)
+	})
+	t.Run("Erase non-existing order", func(t *testing.T) {
+		_, err := pgMockStorage.ErasePII(ctx, "nihil", storage.Erasure{})
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	})
+	t.Run("Delete", func(t *testing.T) {
+		require.NoError(t, pgMockStorage.Delete(ctx, "erase-1"))
+		_, err := pgMockStorage.Get(ctx, "erase-1")
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+		assert.ErrorIs(t, pgMockStorage.Delete(ctx, "erase-1"), storage.ErrNotFound)
+
+		erasures, err := pgMockStorage.Erasures(ctx, "erase-1")
+		require.NoError(t, err)
+		assert.Len(t, erasures, 1, "the audit log must be kept after the order is deleted")
+	})
+}
@@ -0,0 +1,28 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+)
+
+// Ping checks that the database is reachable within the read timeout.
+func (s *Storage) Ping(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx, s.readTimeout)
+	defer cancel()
+	return s.db.PingContext(ctx)
+}
+
+// QueueLen returns the number of the orders waiting in the store queue and the capacity of the queue.
+// The queue is used only in ModeAsync.
+func (s *Storage) QueueLen() (int, int) {
+	return len(s.storeCh), cap(s.storeCh)
+}
+
+// CheckQueue returns an error if the store queue is 90% full: the storer does not keep up
+// with the incoming orders and Store is about to block.
+func (s *Storage) CheckQueue(ctx context.Context) error {
+	if n, size := s.QueueLen(); n >= size*9/10 {
+		return fmt.Errorf("storage: postgres: store queue is backed up: %d of %d", n, size)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+// sortKeys describes how the orders are sorted by every field.
+// The expressions must match the indexes created by the migrations.
+var sortKeys = map[storage.SortField]struct {
+	expr     string // the sort key expression
+	castType string // the type of the cursor key parameter
+}{
+	storage.SortByUID:    {expr: "uid", castType: "TEXT"},
+	storage.SortByDate:   {expr: "COALESCE(order_created(json_order), '-infinity')", castType: "TIMESTAMPTZ"},
+	storage.SortByAmount: {expr: "order_amount(json_order)", castType: "BIGINT"},
+}
+
+// List implements storage.Storage interface.
+// The orders are read using the keyset pagination over the indexed sort keys.
+// The filter is pushed down to the JSONB containment, customer and date range conditions.
+func (s *Storage) List(ctx context.Context, q storage.ListQuery) (storage.ListPage, error) {
+	q, err := q.Normalize()
+	if err != nil {
+		return storage.ListPage{}, err
+	}
+	cursor, err := q.Cursor()
+	if err != nil {
+		return storage.ListPage{}, err
+	}
+	ctx, cancel := withTimeout(ctx, s.readTimeout)
+	defer cancel()
+
+	key := sortKeys[q.SortBy]
+	// the orders are read in the reverse order for the previous page
+	desc := q.Desc != (q.Before != "")
+	op, dir := ">", "ASC"
+	if desc {
+		op, dir = "<", "DESC"
+	}
+	args := []interface{}{q.Limit + 1}
+	conds := filterConditions(q.Filter, &args)
+	if cursor != nil {
+		args = append(args, cursor.Key, cursor.UID)
+		conds = append(conds, fmt.Sprintf("(%s, uid) %s ($%d::%s, $%d)", key.expr, op, len(args)-1, key.castType, len(args)))
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+	query := fmt.Sprintf(`SELECT uid, json_order, version, order_created(json_order), order_amount(json_order)
+		FROM orders %s ORDER BY %s %s, uid %s LIMIT $1;`, where, key.expr, dir, dir)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return storage.ListPage{}, err
+	}
+	defer rows.Close()
+	orders := make([]storage.OrderDB, 0, q.Limit+1)
+	keys := make([]string, 0, q.Limit+1)
+	for rows.Next() {
+		var (
+			o       storage.OrderDB
+			created sql.NullTime
+			amount  int64
+		)
+		if err := rows.Scan(&o.OrderUID, &o.JSONOrder, &o.Version, &created, &amount); err != nil {
+			return storage.ListPage{}, err
+		}
+		orders = append(orders, o)
+		switch q.SortBy {
+		case storage.SortByDate:
+			if created.Valid {
+				keys = append(keys, created.Time.UTC().Format(time.RFC3339Nano))
+			} else {
+				keys = append(keys, storage.MinDateKey)
+			}
+		case storage.SortByAmount:
+			keys = append(keys, strconv.FormatInt(amount, 10))
+		default:
+			keys = append(keys, o.OrderUID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return storage.ListPage{}, err
+	}
+	return storage.NewListPage(q, orders, keys), nil
+}
+
+// filterConditions returns the SQL conditions for the filter and appends their arguments to args.
+// The exact matches are checked by the JSONB containment operator backed by the GIN index.
+func filterConditions(f storage.Filter, args *[]interface{}) []string {
+	var conds []string
+	arg := func(v interface{}) int {
+		*args = append(*args, v)
+		return len(*args)
+	}
+	// pattern is the JSON document the order must contain
+	pattern := make(map[string]interface{})
+	set := func(obj map[string]interface{}, field, value string) {
+		if value != "" {
+			obj[field] = value
+		}
+	}
+	set(pattern, "track_number", f.TrackNumber)
+	set(pattern, "delivery_service", f.DeliveryService)
+	delivery := make(map[string]interface{})
+	set(delivery, "email", f.Email)
+	set(delivery, "phone", f.Phone)
+	set(delivery, "city", f.City)
+	if len(delivery) > 0 {
+		pattern["delivery"] = delivery
+	}
+	payment := make(map[string]interface{})
+	set(payment, "bank", f.Bank)
+	set(payment, "transaction", f.Transaction)
+	if len(payment) > 0 {
+		pattern["payment"] = payment
+	}
+	if f.Brand != "" {
+		pattern["items"] = []map[string]string{{"brand": f.Brand}}
+	}
+	if len(pattern) > 0 {
+		data, err := json.Marshal(pattern)
+		if err != nil {
+			panic("unreachable: " + err.Error())
+		}
+		conds = append(conds, fmt.Sprintf("json_order @> $%d::JSONB", arg(string(data))))
+	}
+	if f.CustomerID != "" {
+		// the customer's orders are looked up by the dedicated index, see migration 0009
+		conds = append(conds, fmt.Sprintf("json_order->>'customer_id' = $%d", arg(f.CustomerID)))
+	}
+	if !f.CreatedFrom.IsZero() {
+		conds = append(conds, fmt.Sprintf("order_created(json_order) >= $%d", arg(f.CreatedFrom)))
+	}
+	if !f.CreatedTo.IsZero() {
+		conds = append(conds, fmt.Sprintf("order_created(json_order) < $%d", arg(f.CreatedTo)))
+	}
+	return conds
+}
@@ -0,0 +1,155 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+func TestList(t *testing.T) {
+	defer cleanOrdersTable(t)
+	ctx := context.Background()
+	for i := 1; i <= 10; i++ {
+		// the amounts go in reverse order, the dates are not unique
+		o := fmt.Sprintf(`{"id":%d,"date_created":"2021-11-%02dT06:22:19Z","payment":{"amount":%d}}`, i, 1+i/2, 100-i)
+		_, err := pgMockStorage.Upsert(ctx, fmt.Sprintf("%02d", i), o)
+		require.NoError(t, err)
+	}
+	_, err := pgMockStorage.Upsert(ctx, "00", `{"id":0}`)
+	require.NoError(t, err)
+
+	uids := func(page storage.ListPage) []string {
+		res := make([]string, 0, len(page.Orders))
+		for _, o := range page.Orders {
+			res = append(res, o.OrderUID)
+		}
+		return res
+	}
+	tests := []struct {
+		name  string
+		query storage.ListQuery
+		pages [][]string
+	}{
+		{
+			name:  "By UID",
+			query: storage.ListQuery{Limit: 4},
+			pages: [][]string{{"00", "01", "02", "03"}, {"04", "05", "06", "07"}, {"08", "09", "10"}},
+		},
+		{
+			name:  "By date descending",
+			query: storage.ListQuery{SortBy: storage.SortByDate, Desc: true, Limit: 3},
+			pages: [][]string{{"10", "09", "08"}, {"07", "06", "05"}, {"04", "03", "02"}, {"01", "00"}},
+		},
+		{
+			name:  "By amount",
+			query: storage.ListQuery{SortBy: storage.SortByAmount, Limit: 5},
+			pages: [][]string{{"00", "10", "09", "08", "07"}, {"06", "05", "04", "03", "02"}, {"01"}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			q := tc.query
+			var page storage.ListPage
+			for i, want := range tc.pages {
+				page, err = pgMockStorage.List(ctx, q)
+				require.NoError(t, err)
+				assert.Equal(t, want, uids(page), "page %d", i)
+				q.After, q.Before = page.Next, ""
+			}
+			assert.Empty(t, page.Next, "no next page after the last one")
+			for i := len(tc.pages) - 2; i >= 0; i-- {
+				q.After, q.Before = "", page.Prev
+				page, err = pgMockStorage.List(ctx, q)
+				require.NoError(t, err)
+				assert.Equal(t, tc.pages[i], uids(page), "page %d backwards", i)
+			}
+			assert.Empty(t, page.Prev, "no previous page before the first one")
+		})
+	}
+}
+
+func TestListFilter(t *testing.T) {
+	defer cleanOrdersTable(t)
+	ctx := context.Background()
+	orders := map[string]string{
+		"1": `{"track_number":"T1","customer_id":"c1","delivery_service":"meest","date_created":"2021-11-01T10:00:00Z","delivery":{"city":"Moscow","email":"a@b.c"},"payment":{"bank":"alpha"},"items":[{"brand":"Vivienne Sabo"},{"brand":"Nike"}]}`,
+		"2": `{"track_number":"T2","customer_id":"c2","delivery_service":"meest","date_created":"2021-11-02T10:00:00Z","delivery":{"city":"Moscow","email":"d@e.f"},"payment":{"bank":"sber"},"items":[{"brand":"Nike"}]}`,
+		"3": `{"track_number":"T3","customer_id":"c1","delivery_service":"cdek","date_created":"2021-11-03T10:00:00Z","delivery":{"city":"Kazan","email":"a@b.c"},"payment":{"bank":"alpha"},"items":[]}`,
+		"4": `{"track_number":"T4"}`,
+	}
+	for uid, o := range orders {
+		_, err := pgMockStorage.Upsert(ctx, uid, o)
+		require.NoError(t, err)
+	}
+	day := func(d int) time.Time { return time.Date(2021, 11, d, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name   string
+		filter storage.Filter
+		want   []string
+	}{
+		{"No filter", storage.Filter{}, []string{"1", "2", "3", "4"}},
+		{"Track number", storage.Filter{TrackNumber: "T2"}, []string{"2"}},
+		{"Customer", storage.Filter{CustomerID: "c1"}, []string{"1", "3"}},
+		{"Customer and delivery service", storage.Filter{CustomerID: "c1", DeliveryService: "meest"}, []string{"1"}},
+		{"City and bank", storage.Filter{City: "Moscow", Bank: "alpha"}, []string{"1"}},
+		{"Email", storage.Filter{Email: "a@b.c"}, []string{"1", "3"}},
+		{"Brand", storage.Filter{Brand: "Nike"}, []string{"1", "2"}},
+		{"Date range", storage.Filter{CreatedFrom: day(2), CreatedTo: day(3)}, []string{"2"}},
+		{"Date until", storage.Filter{CreatedTo: day(3)}, []string{"1", "2"}},
+		{"Nothing found", storage.Filter{Bank: "nihil"}, []string{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			page, err := pgMockStorage.List(ctx, storage.ListQuery{Filter: tc.filter})
+			require.NoError(t, err)
+			got := make([]string, 0, len(page.Orders))
+			for _, o := range page.Orders {
+				got = append(got, o.OrderUID)
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestOrderCreated(t *testing.T) {
+	defer cleanOrdersTable(t)
+	ctx := context.Background()
+	tests := []struct {
+		date string
+		want *time.Time
+	}{
+		{date: "2021-11-26T06:22:19Z", want: timePtr(time.Date(2021, 11, 26, 6, 22, 19, 0, time.UTC))},
+		{date: "2021-11-26T09:22:19.5+03:00", want: timePtr(time.Date(2021, 11, 26, 6, 22, 19, 5e8, time.UTC))},
+		{date: "2021-11-26T06:22:19"}, // no time zone
+		{date: "2021-11-26"},
+		{date: "2021-13-26T06:22:19Z"},
+		{date: "now"},
+		{date: "garbage"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.date, func(t *testing.T) {
+			var got sql.NullTime
+			err := pgMockStorage.db.QueryRowContext(ctx, `SELECT order_created(jsonb_build_object('date_created', $1::TEXT));`,
+				tc.date).Scan(&got)
+			require.NoError(t, err)
+			if tc.want == nil {
+				assert.False(t, got.Valid, "the malformed date must be NULL: %v", got.Time)
+				return
+			}
+			require.True(t, got.Valid)
+			assert.True(t, tc.want.Equal(got.Time), "%v", got.Time)
+		})
+	}
+	_, err := pgMockStorage.Upsert(ctx, "malformed", `{"date_created":"2021-13-26T06:22:19Z"}`)
+	assert.NoError(t, err, "the order with the malformed date must be stored")
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,76 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+const (
+	changesChannel = "order_changes" // see notify_order_change() in the migrations
+
+	listenReconnectDelay = time.Second
+)
+
+var _ storage.ChangeNotifier = (*Storage)(nil)
+
+// Listen implements storage.ChangeNotifier interface.
+// It listens to the notifications sent by the trigger on the orders table using a dedicated connection.
+// If the connection is lost, Listen reconnects and reports storage.OpReset, because
+// the notifications sent while the connection was lost are missed.
+// Listen returns only when the context is canceled.
+func (s *Storage) Listen(ctx context.Context, fn func(storage.Change)) error {
+	reconnect := false
+	for {
+		err := s.listen(ctx, fn, reconnect)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("storage: postgres: ERR: listening to the changes: %s, reconnecting...", err)
+		reconnect = true
+		select {
+		case <-time.After(listenReconnectDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// listen connects to the database and passes all the notifications to fn until an error occurs.
+func (s *Storage) listen(ctx context.Context, fn func(storage.Change), reconnect bool) error {
+	conn, err := pgx.Connect(ctx, s.databaseURI)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+	if _, err := conn.Exec(ctx, "LISTEN "+changesChannel); err != nil {
+		return err
+	}
+	if reconnect {
+		fn(storage.Change{Op: storage.OpReset})
+	}
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var payload struct {
+			Op      string `json:"op"`
+			UID     string `json:"uid"`
+			Version int64  `json:"version"`
+		}
+		if err := json.Unmarshal([]byte(n.Payload), &payload); err != nil {
+			log.Printf("storage: postgres: ERR: incorrect notification %q: %s", n.Payload, err)
+			continue
+		}
+		fn(storage.Change{
+			Op:       storage.ChangeOp(payload.Op),
+			OrderUID: payload.UID,
+			Version:  payload.Version,
+		})
+	}
+}
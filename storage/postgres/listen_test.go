@@ -0,0 +1,44 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+func TestListen(t *testing.T) {
+	defer cleanOrdersTable(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan storage.Change, 10)
+	done := make(chan error)
+	go func() {
+		done <- pgMockStorage.Listen(ctx, func(c storage.Change) { changes <- c })
+	}()
+	// let the listener connect
+	time.Sleep(500 * time.Millisecond)
+
+	expect := func(op storage.ChangeOp, uid string, version int64) {
+		select {
+		case c := <-changes:
+			assert.Equal(t, storage.Change{Op: op, OrderUID: uid, Version: version}, c)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no notification about %s of the order %s", op, uid)
+		}
+	}
+	_, err := pgMockStorage.db.Exec(`INSERT INTO orders (uid, json_order) VALUES ('listen-1', '{}')`)
+	require.NoError(t, err)
+	expect(storage.OpInsert, "listen-1", 1)
+	_, err = pgMockStorage.Upsert(context.Background(), "listen-1", `{"updated":true}`)
+	require.NoError(t, err)
+	expect(storage.OpUpdate, "listen-1", 2)
+	_, err = pgMockStorage.db.Exec(`DELETE FROM orders WHERE uid = 'listen-1'`)
+	require.NoError(t, err)
+	expect(storage.OpDelete, "listen-1", 0)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
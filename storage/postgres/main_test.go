@@ -25,6 +25,7 @@ const (
 
 var (
 	pgMockStorage *Storage
+	pgMockDSN     string
 )
 
 type postgresContainer struct {
@@ -46,7 +47,8 @@ func TestMain(m *testing.M) {
 	if err := pgContainer.Start(ctx); err != nil {
 		log.Fatal(err)
 	}
-	pg, err := NewStorage(pgContainer.GetDSN())
+	pgMockDSN = pgContainer.GetDSN()
+	pg, err := NewStorage(ctx, pgMockDSN)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -93,7 +95,7 @@ func setupPostgresContainer(ctx context.Context) (*postgresContainer, error) {
 }
 
 // cleanOrdersTable removes all records from the 'orders' table in the database.
-func cleanOrdersTable(t *testing.T) {
+func cleanOrdersTable(tb testing.TB) {
 	_, err := pgMockStorage.db.Exec(`DELETE FROM orders;`)
-	require.NoErrorf(t, err, "could not delete all records from the orders table")
+	require.NoErrorf(tb, err, "could not delete all records from the orders table")
 }
@@ -0,0 +1,97 @@
+package postgres
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Write operations, the values of the op label.
+const (
+	opInsert      = "insert"
+	opInsertBatch = "insert_batch"
+	opUpdate      = "update"
+	opUpsert      = "upsert"
+	opDelete      = "delete"
+	opErase       = "erase"
+)
+
+// storageMetrics are the metrics of the writes; the queue and the storer metrics are read
+// from the storage state by the collectors created in WithMetrics.
+type storageMetrics struct {
+	writeDuration *prometheus.HistogramVec
+	writeErrors   *prometheus.CounterVec
+}
+
+func newStorageMetrics() storageMetrics {
+	return storageMetrics{
+		writeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "orderserver",
+			Subsystem: "postgres",
+			Name:      "write_duration_seconds",
+			Help:      "The latency of the writes to the database, including the failed ones.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"op"}),
+		writeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "orderserver",
+			Subsystem: "postgres",
+			Name:      "write_errors_total",
+			Help:      "The number of the failed writes to the database.",
+		}, []string{"op"}),
+	}
+}
+
+// observeWrite records the write operation started at the given time.
+func (m storageMetrics) observeWrite(op string, start time.Time, err error) {
+	m.writeDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.writeErrors.WithLabelValues(op).Inc()
+	}
+}
+
+// WithMetrics registers the metrics of the storage: the write latency and errors,
+// the occupancy of the store queue and the storer counters (see Stats).
+func WithMetrics(reg prometheus.Registerer) StorageOpt {
+	return func(s *Storage) error {
+		if reg == nil {
+			return errors.New("nil metrics registry")
+		}
+		counterFunc := func(name, help string, v *uint64) prometheus.Collector {
+			return prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Namespace: "orderserver",
+				Subsystem: "postgres",
+				Name:      name,
+				Help:      help,
+			}, func() float64 { return float64(atomic.LoadUint64(v)) })
+		}
+		collectors := []prometheus.Collector{
+			s.metrics.writeDuration,
+			s.metrics.writeErrors,
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Namespace: "orderserver",
+				Subsystem: "postgres",
+				Name:      "store_queue_length",
+				Help:      "The number of the orders waiting in the store queue.",
+			}, func() float64 { return float64(len(s.storeCh)) }),
+			prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+				Namespace: "orderserver",
+				Subsystem: "postgres",
+				Name:      "store_queue_capacity",
+				Help:      "The capacity of the store queue.",
+			}, func() float64 { return float64(cap(s.storeCh)) }),
+			counterFunc("store_retries_total", "The number of the retried inserts.", &s.counters.retries),
+			counterFunc("store_give_ups_total", "The number of the orders given up after exhausting the attempts.",
+				&s.counters.giveUps),
+			counterFunc("store_failures_total", "The number of the orders failed with a permanent error.",
+				&s.counters.failed),
+		}
+		for _, c := range collectors {
+			if err := reg.Register(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
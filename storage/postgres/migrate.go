@@ -0,0 +1,287 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// migrationsLockID is the key of the advisory lock that prevents concurrent migrations.
+const migrationsLockID = 0x4f524445 // "ORDE"
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrationFileRegex matches the names of the migration files: <version>_<name>.<up|down>.sql
+var migrationFileRegex = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// ErrSchemaOutdated is returned by NewStorage if the database schema is not up to date
+// and the automatic migration is disabled.
+var ErrSchemaOutdated = errors.New("storage: postgres: database schema is outdated, run the migrations")
+
+type (
+	// Migrator applies the embedded schema migrations to the database.
+	//
+	// The migrations are numbered from 1 without gaps, every migration has up and down scripts.
+	// The applied migrations are recorded in the schema_migrations table; every migration is applied
+	// in its own transaction along with the record. The concurrent migrations (e.g. by several
+	// instances starting at once) are serialized by the advisory lock.
+	//
+	// NB the migrations that reproduce the schema created before the migrations were introduced
+	// are idempotent, so such databases are adopted by applying all the migrations.
+	Migrator struct {
+		db         *sql.DB
+		ownDB      bool // the db is opened by the migrator and must be closed by it
+		migrations []migration
+	}
+
+	// migration is the embedded migration.
+	migration struct {
+		version int
+		name    string
+		up      string
+		down    string
+	}
+
+	// MigrationStatus describes the migration and its state in the database.
+	MigrationStatus struct {
+		Version   int
+		Name      string
+		AppliedAt time.Time // zero if the migration is not applied
+	}
+)
+
+// NewMigrator connects to the database for running the migrations. The migrator must be closed after use.
+func NewMigrator(ctx context.Context, databaseURI string) (*Migrator, error) {
+	db, err := sql.Open("pgx", databaseURI)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	m, err := newMigrator(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	m.ownDB = true
+	return m, nil
+}
+
+// newMigrator creates the migrator using the given database handle.
+func newMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Close closes the database connection opened by NewMigrator.
+func (m *Migrator) Close() error {
+	if !m.ownDB {
+		return nil
+	}
+	return m.db.Close()
+}
+
+// Latest returns the version of the latest migration.
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Up applies all the pending migrations. The versions of the applied migrations are returned.
+func (m *Migrator) Up(ctx context.Context) ([]int, error) {
+	return m.To(ctx, m.Latest())
+}
+
+// To migrates the database up or down to the given version; version 0 means that all the migrations are reverted.
+// The versions of the applied (or reverted) migrations are returned in the order they have been run.
+func (m *Migrator) To(ctx context.Context, target int) ([]int, error) {
+	if target < 0 || target > m.Latest() {
+		return nil, fmt.Errorf("storage: postgres: unknown schema version %d, the latest is %d", target, m.Latest())
+	}
+	var done []int
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		current, err := currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if current > m.Latest() {
+			return fmt.Errorf("storage: postgres: database schema version %d is newer than the latest known %d",
+				current, m.Latest())
+		}
+		for ; current < target; current++ {
+			mg := m.migrations[current]
+			if err := m.run(ctx, conn, mg.up, `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, now());`,
+				mg.version, mg.name); err != nil {
+				return fmt.Errorf("storage: postgres: migration %d_%s: %w", mg.version, mg.name, err)
+			}
+			log.Printf("storage: postgres: migration %d_%s applied", mg.version, mg.name)
+			done = append(done, mg.version)
+		}
+		for ; current > target; current-- {
+			mg := m.migrations[current-1]
+			if err := m.run(ctx, conn, mg.down, `DELETE FROM schema_migrations WHERE version = $1 AND name = $2;`,
+				mg.version, mg.name); err != nil {
+				return fmt.Errorf("storage: postgres: reverting migration %d_%s: %w", mg.version, mg.name, err)
+			}
+			log.Printf("storage: postgres: migration %d_%s reverted", mg.version, mg.name)
+			done = append(done, mg.version)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status returns the state of all the known migrations.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied := make(map[int]time.Time)
+		rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations;`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var (
+				version   int
+				appliedAt time.Time
+			)
+			if err := rows.Scan(&version, &appliedAt); err != nil {
+				return err
+			}
+			applied[version] = appliedAt
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, mg := range m.migrations {
+			statuses = append(statuses, MigrationStatus{Version: mg.version, Name: mg.name, AppliedAt: applied[mg.version]})
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// Pending reports whether there are migrations that are not applied.
+func (m *Migrator) Pending(ctx context.Context) (bool, error) {
+	var current int
+	err := m.locked(ctx, func(conn *sql.Conn) (err error) {
+		current, err = currentVersion(ctx, conn)
+		return
+	})
+	return current < m.Latest(), err
+}
+
+// locked calls fn holding the advisory lock on a dedicated connection.
+// The schema_migrations table is created if it does not exist.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) (retErr error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1);`, migrationsLockID); err != nil {
+		return err
+	}
+	defer func() {
+		// the lock must be released even if the context is canceled
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1);`, migrationsLockID); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL
+	);`); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// run executes the migration script and the query recording it within a transaction.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, script, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// currentVersion returns the version of the latest applied migration, 0 if none is applied.
+func currentVersion(ctx context.Context, conn *sql.Conn) (int, error) {
+	var version int
+	err := conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations;`).Scan(&version)
+	return version, err
+}
+
+// loadMigrations reads the embedded migrations and checks that they are numbered from 1 without gaps
+// and every migration has both up and down scripts.
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationsFS.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*migration)
+	for _, e := range entries {
+		match := migrationFileRegex.FindStringSubmatch(e.Name())
+		if match == nil {
+			return nil, fmt.Errorf("storage: postgres: incorrect migration file name %q", e.Name())
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, err
+		}
+		data, err := migrationsFS.ReadFile(path.Join("migrations", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		mg, ok := byVersion[version]
+		if !ok {
+			mg = &migration{version: version, name: match[2]}
+			byVersion[version] = mg
+		}
+		if mg.name != match[2] {
+			return nil, fmt.Errorf("storage: postgres: migration %d has different names %q and %q", version, mg.name, match[2])
+		}
+		if match[3] == "up" {
+			mg.up = string(data)
+		} else {
+			mg.down = string(data)
+		}
+	}
+	migrations := make([]migration, 0, len(byVersion))
+	for _, mg := range byVersion {
+		if mg.up == "" || mg.down == "" {
+			return nil, fmt.Errorf("storage: postgres: migration %d_%s must have both up and down scripts", mg.version, mg.name)
+		}
+		migrations = append(migrations, *mg)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	for i, mg := range migrations {
+		if mg.version != i+1 {
+			return nil, fmt.Errorf("storage: postgres: migration %d is missing", i+1)
+		}
+	}
+	return migrations, nil
+}
@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for i, mg := range migrations {
+		assert.Equal(t, i+1, mg.version)
+		assert.NotEmpty(t, mg.up, "migration %d", mg.version)
+		assert.NotEmpty(t, mg.down, "migration %d", mg.version)
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	m, err := NewMigrator(ctx, pgMockDSN)
+	require.NoError(t, err)
+	defer m.Close()
+
+	t.Run("All applied by NewStorage", func(t *testing.T) {
+		statuses, err := m.Status(ctx)
+		require.NoError(t, err)
+		require.Len(t, statuses, m.Latest())
+		for _, st := range statuses {
+			assert.False(t, st.AppliedAt.IsZero(), "migration %d_%s", st.Version, st.Name)
+		}
+		pending, err := m.Pending(ctx)
+		require.NoError(t, err)
+		assert.False(t, pending)
+	})
+	t.Run("Down and up again", func(t *testing.T) {
+		reverted, err := m.To(ctx, 0)
+		require.NoError(t, err)
+		assert.Len(t, reverted, m.Latest())
+		_, err = NewStorage(ctx, pgMockDSN, WithAutoMigrate(false))
+		assert.ErrorIs(t, err, ErrSchemaOutdated)
+
+		// concurrent migrations must not interfere
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			applied []int
+		)
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				done, err := m.Up(ctx)
+				assert.NoError(t, err)
+				mu.Lock()
+				applied = append(applied, done...)
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+		assert.Len(t, applied, m.Latest(), "every migration must be applied once")
+	})
+	t.Run("Unknown version", func(t *testing.T) {
+		_, err := m.To(ctx, m.Latest()+1)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,2 @@
+-- version is incremented on every update of the order (optimistic concurrency control).
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,11 @@
+-- order_erasures is the audit log of the erasures of the customers' personal data (see Storage.ErasePII).
+-- The entries are kept after the order is deleted.
+CREATE TABLE IF NOT EXISTS order_erasures (
+    id BIGSERIAL PRIMARY KEY,
+    uid TEXT NOT NULL,
+    requester TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    erased_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS order_erasures_uid_idx ON order_erasures (uid);
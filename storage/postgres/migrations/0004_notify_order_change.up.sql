@@ -0,0 +1,16 @@
+-- notify_order_change sends the notification about every change of the orders table
+-- to the other instances of the app (see Storage.Listen).
+CREATE OR REPLACE FUNCTION notify_order_change() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('order_changes', json_build_object('op', TG_OP, 'uid', OLD.uid)::text);
+        RETURN OLD;
+    END IF;
+    PERFORM pg_notify('order_changes', json_build_object('op', TG_OP, 'uid', NEW.uid, 'version', NEW.version)::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER orders_notify_change
+    AFTER INSERT OR UPDATE OR DELETE ON orders
+    FOR EACH ROW EXECUTE FUNCTION notify_order_change();
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS orders_created_idx;
+DROP FUNCTION IF EXISTS order_created(JSONB);
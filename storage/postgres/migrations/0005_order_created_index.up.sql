@@ -0,0 +1,8 @@
+-- order_created extracts the creation time of the order. The function is declared IMMUTABLE
+-- to be used in the indexes: the dates are always stored in RFC3339 format with the time zone,
+-- so the result does not depend on the session settings.
+CREATE OR REPLACE FUNCTION order_created(json_order JSONB) RETURNS TIMESTAMPTZ AS $$
+    SELECT (json_order->>'date_created')::TIMESTAMPTZ
+$$ LANGUAGE SQL IMMUTABLE;
+
+CREATE INDEX IF NOT EXISTS orders_created_idx ON orders (order_created(json_order) DESC NULLS LAST);
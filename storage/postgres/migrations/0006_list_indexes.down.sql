@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS orders_list_created_idx;
+DROP INDEX IF EXISTS orders_list_amount_idx;
+DROP FUNCTION IF EXISTS order_amount(JSONB);
@@ -0,0 +1,9 @@
+-- order_amount extracts the amount of the order's payment, 0 if it is missing.
+CREATE OR REPLACE FUNCTION order_amount(json_order JSONB) RETURNS BIGINT AS $$
+    SELECT COALESCE((json_order->'payment'->>'amount')::BIGINT, 0)
+$$ LANGUAGE SQL IMMUTABLE;
+
+-- the indexes for the keyset pagination (see Storage.List); the orders without the creation date
+-- go first in ascending order.
+CREATE INDEX IF NOT EXISTS orders_list_created_idx ON orders (COALESCE(order_created(json_order), '-infinity'), uid);
+CREATE INDEX IF NOT EXISTS orders_list_amount_idx ON orders (order_amount(json_order), uid);
@@ -0,0 +1,2 @@
+-- the index for searching the orders by the exact values of the fields (see Storage.List).
+CREATE INDEX IF NOT EXISTS orders_json_idx ON orders USING GIN (json_order jsonb_path_ops);
@@ -0,0 +1,64 @@
+-- The normalized schema mirrors the orders stored in the json_order column for reporting
+-- (see WithNormalizedSchema). The rows are removed along with the orders.
+CREATE SCHEMA IF NOT EXISTS normalized;
+
+CREATE TABLE IF NOT EXISTS normalized.orders (
+    uid TEXT PRIMARY KEY REFERENCES public.orders (uid) ON DELETE CASCADE,
+    version BIGINT NOT NULL,
+    track_number TEXT NOT NULL,
+    entry TEXT NOT NULL,
+    locale TEXT NOT NULL,
+    internal_signature TEXT NOT NULL,
+    shardkey TEXT NOT NULL,
+    sm_id INTEGER NOT NULL,
+    date_created TIMESTAMPTZ NOT NULL,
+    -- the time zone offset of date_created in seconds, used to reconstruct the original order
+    date_created_offset INTEGER NOT NULL,
+    oof_shard TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS normalized.deliveries (
+    order_uid TEXT PRIMARY KEY REFERENCES normalized.orders (uid) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    phone TEXT NOT NULL,
+    zip TEXT NOT NULL,
+    city TEXT NOT NULL,
+    address TEXT NOT NULL,
+    region TEXT NOT NULL,
+    email TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS normalized.payments (
+    order_uid TEXT PRIMARY KEY REFERENCES normalized.orders (uid) ON DELETE CASCADE,
+    transaction TEXT NOT NULL,
+    request_id TEXT NOT NULL,
+    currency TEXT NOT NULL,
+    provider TEXT NOT NULL,
+    amount BIGINT NOT NULL,
+    payment_dt BIGINT NOT NULL,
+    bank TEXT NOT NULL,
+    delivery_cost BIGINT NOT NULL,
+    goods_total BIGINT NOT NULL,
+    custom_fee BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS normalized.items (
+    order_uid TEXT NOT NULL REFERENCES normalized.orders (uid) ON DELETE CASCADE,
+    -- the position of the item in the order
+    position INTEGER NOT NULL,
+    chrt_id BIGINT NOT NULL,
+    track_number TEXT NOT NULL,
+    price BIGINT NOT NULL,
+    rid TEXT NOT NULL,
+    name TEXT NOT NULL,
+    sale INTEGER NOT NULL,
+    size TEXT NOT NULL,
+    total_price BIGINT NOT NULL,
+    nm_id BIGINT NOT NULL,
+    brand TEXT NOT NULL,
+    status INTEGER NOT NULL,
+    PRIMARY KEY (order_uid, position)
+);
+
+CREATE INDEX IF NOT EXISTS items_brand_idx ON normalized.items (brand);
+CREATE INDEX IF NOT EXISTS orders_date_created_idx ON normalized.orders (date_created);
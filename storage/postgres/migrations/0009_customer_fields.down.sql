@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS normalized.normalized_orders_customer_idx;
+ALTER TABLE normalized.orders DROP COLUMN IF EXISTS delivery_service;
+ALTER TABLE normalized.orders DROP COLUMN IF EXISTS customer_id;
+DROP INDEX IF EXISTS orders_customer_idx;
@@ -0,0 +1,13 @@
+-- the index for looking up the orders of the customer (see Storage.List); the orders of the customer
+-- are paginated by UID.
+CREATE INDEX IF NOT EXISTS orders_customer_idx ON orders ((json_order->>'customer_id'), uid);
+
+-- customer_id and delivery_service are added to the normalized orders; the existing rows are filled
+-- from the stored orders since their versions are not changed.
+ALTER TABLE normalized.orders ADD COLUMN IF NOT EXISTS customer_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE normalized.orders ADD COLUMN IF NOT EXISTS delivery_service TEXT NOT NULL DEFAULT '';
+UPDATE normalized.orders n
+    SET customer_id = COALESCE(o.json_order->>'customer_id', ''),
+        delivery_service = COALESCE(o.json_order->>'delivery_service', '')
+    FROM orders o WHERE o.uid = n.uid;
+CREATE INDEX IF NOT EXISTS normalized_orders_customer_idx ON normalized.orders (customer_id);
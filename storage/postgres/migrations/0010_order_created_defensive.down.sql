@@ -0,0 +1,6 @@
+CREATE OR REPLACE FUNCTION order_created(json_order JSONB) RETURNS TIMESTAMPTZ AS $$
+    SELECT (json_order->>'date_created')::TIMESTAMPTZ
+$$ LANGUAGE SQL IMMUTABLE;
+
+REINDEX INDEX orders_created_idx;
+REINDEX INDEX orders_list_created_idx;
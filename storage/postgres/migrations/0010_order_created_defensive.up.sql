@@ -0,0 +1,21 @@
+-- order_created is redefined to be really immutable and to never fail: only the dates in RFC3339 format
+-- with the explicit time zone are parsed (in the UTC session zone, so neither the TimeZone setting nor
+-- the special values like 'now' affect the result), and NULL is returned for any other or malformed date,
+-- so an invalid date_created does not make the expression indexes reject the whole order.
+CREATE OR REPLACE FUNCTION order_created(json_order JSONB) RETURNS TIMESTAMPTZ AS $$
+DECLARE
+    created TEXT := json_order->>'date_created';
+BEGIN
+    IF created IS NULL OR created !~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})$' THEN
+        RETURN NULL;
+    END IF;
+    RETURN created::TIMESTAMPTZ;
+EXCEPTION
+    WHEN data_exception THEN
+        RETURN NULL;
+END
+$$ LANGUAGE plpgsql IMMUTABLE SET TimeZone = 'UTC';
+
+-- the indexes are rebuilt since the function could return other values for the stored orders
+REINDEX INDEX orders_created_idx;
+REINDEX INDEX orders_list_created_idx;
@@ -0,0 +1 @@
+ALTER TABLE normalized.orders DROP COLUMN IF EXISTS extra;
@@ -0,0 +1,6 @@
+-- extra is the JSON Patch (RFC 6902) restoring the stored order from the one built of the normalized columns:
+-- the fields unknown to models.Order and the values the columns could not represent (see Storage.GetNormalized).
+ALTER TABLE normalized.orders ADD COLUMN IF NOT EXISTS extra JSONB NOT NULL DEFAULT '[]';
+-- the patches of the orders normalized before could not be computed here, so the orders are marked stale
+-- to be written again by Storage.Backfill.
+UPDATE normalized.orders SET version = 0;
@@ -0,0 +1,270 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/vanamelnik/wildberries-L0/models"
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+// queryer is implemented by both *sql.DB and *sql.Tx.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// WithNormalizedSchema makes the storage write every order also to the tables of the normalized
+// schema (orders, deliveries, payments and items) in the same transaction. The tables are created
+// by the migrations regardless of the option. The orders that could
+// not be parsed as models.Order are not stored at all.
+// NB all the instances writing to the database must use the option, otherwise the normalized tables
+// become stale; Backfill brings them up to date.
+func WithNormalizedSchema() StorageOpt {
+	return func(s *Storage) error {
+		s.normalized = true
+		return nil
+	}
+}
+
+// write calls fn within a transaction if the normalized schema is enabled, so the order and
+// its normalized rows are written atomically. Otherwise fn is called with the database handle.
+// The write is recorded to the metrics as the given operation.
+func (s *Storage) write(ctx context.Context, op string, fn func(q queryer) error) (err error) {
+	defer func(start time.Time) { s.metrics.observeWrite(op, start, err) }(time.Now())
+	if !s.normalized {
+		return fn(s.db)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// normalize writes the order to the normalized tables replacing the previous version of the order.
+// It does nothing unless the normalized schema is enabled.
+func (s *Storage) normalize(ctx context.Context, q queryer, orderUID, jsonOrder string, version int64) error {
+	if !s.normalized {
+		return nil
+	}
+	var o models.Order
+	if err := json.Unmarshal([]byte(jsonOrder), &o); err != nil {
+		return fmt.Errorf("storage: postgres: could not parse order %s: %w", orderUID, err)
+	}
+	rebuilt, err := json.Marshal(o)
+	if err != nil {
+		return err
+	}
+	patch, err := diffJSON([]byte(jsonOrder), rebuilt)
+	if err != nil {
+		return fmt.Errorf("storage: postgres: could not compare order %s with the normalized one: %w", orderUID, err)
+	}
+	extra, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	// the children rows are removed by cascade
+	if _, err := q.ExecContext(ctx, `DELETE FROM normalized.orders WHERE uid = $1;`, orderUID); err != nil {
+		return err
+	}
+	_, offset := o.DateCreated.Zone()
+	if _, err := q.ExecContext(ctx, `INSERT INTO normalized.orders (uid, version, track_number, entry, locale,
+		internal_signature, customer_id, delivery_service, shardkey, sm_id, date_created, date_created_offset, oof_shard, extra)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14);`,
+		orderUID, version, o.TrackNumber, o.Entry, o.Locale, o.InternalSignature, o.CustomerID, o.DeliveryService,
+		o.Shardkey, o.SmID, o.DateCreated, offset, o.OOFShard, string(extra)); err != nil {
+		return err
+	}
+	d := o.Delivery
+	if _, err := q.ExecContext(ctx, `INSERT INTO normalized.deliveries (order_uid, name, phone, zip, city,
+		address, region, email) VALUES ($1, $2, $3, $4, $5, $6, $7, $8);`,
+		orderUID, d.Name, d.Phone, d.ZIP, d.City, d.Address, d.Region, d.Email); err != nil {
+		return err
+	}
+	p := o.Payment
+	if _, err := q.ExecContext(ctx, `INSERT INTO normalized.payments (order_uid, transaction, request_id, currency,
+		provider, amount, payment_dt, bank, delivery_cost, goods_total, custom_fee)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11);`,
+		orderUID, p.Transaction, p.RequestID, p.Currency, p.Provider, p.Amount, int64(p.PaymentDt), p.Bank,
+		p.DeliveryCost, p.GoodsTotal, p.CustomFee); err != nil {
+		return err
+	}
+	for i, item := range o.Items {
+		if _, err := q.ExecContext(ctx, `INSERT INTO normalized.items (order_uid, position, chrt_id, track_number,
+			price, rid, name, sale, size, total_price, nm_id, brand, status)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13);`,
+			orderUID, i, int64(item.ChrtID), item.TrackNumber, item.Price, item.RID, item.Name, item.Sale, item.Size,
+			item.TotalPrice, int64(item.NmID), item.Brand, item.Status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetNormalized reconstructs the JSON order from the normalized tables. The fields unknown to models.Order
+// and the values the columns could not represent are restored from the patch kept along with the order,
+// so the result equals the order returned by Get as a JSON document (the order of the keys is not kept
+// by JSONB either).
+func (s *Storage) GetNormalized(ctx context.Context, orderUID string) (string, error) {
+	ctx, cancel := withTimeout(ctx, s.readTimeout)
+	defer cancel()
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	o := models.Order{OrderUID: orderUID}
+	var (
+		offset    int
+		paymentDt int64
+		extra     []byte
+	)
+	d, p := &o.Delivery, &o.Payment
+	err = tx.QueryRowContext(ctx, `SELECT o.track_number, o.entry, o.locale, o.internal_signature,
+		o.customer_id, o.delivery_service, o.shardkey, o.sm_id, o.date_created, o.date_created_offset, o.oof_shard, o.extra,
+		d.name, d.phone, d.zip, d.city, d.address, d.region, d.email,
+		p.transaction, p.request_id, p.currency, p.provider, p.amount, p.payment_dt, p.bank,
+		p.delivery_cost, p.goods_total, p.custom_fee
+		FROM normalized.orders o
+		JOIN normalized.deliveries d ON d.order_uid = o.uid
+		JOIN normalized.payments p ON p.order_uid = o.uid
+		WHERE o.uid = $1;`, orderUID).Scan(
+		&o.TrackNumber, &o.Entry, &o.Locale, &o.InternalSignature,
+		&o.CustomerID, &o.DeliveryService, &o.Shardkey, &o.SmID, &o.DateCreated, &offset, &o.OOFShard, &extra,
+		&d.Name, &d.Phone, &d.ZIP, &d.City, &d.Address, &d.Region, &d.Email,
+		&p.Transaction, &p.RequestID, &p.Currency, &p.Provider, &p.Amount, &paymentDt, &p.Bank,
+		&p.DeliveryCost, &p.GoodsTotal, &p.CustomFee)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", storage.ErrNotFound
+		}
+		return "", err
+	}
+	o.DateCreated = o.DateCreated.In(time.FixedZone("", offset))
+	p.PaymentDt = uint64(paymentDt)
+
+	rows, err := tx.QueryContext(ctx, `SELECT chrt_id, track_number, price, rid, name, sale, size,
+		total_price, nm_id, brand, status FROM normalized.items WHERE order_uid = $1 ORDER BY position;`, orderUID)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	o.Items = make([]models.Item, 0)
+	for rows.Next() {
+		var (
+			item         models.Item
+			chrtID, nmID int64
+		)
+		if err := rows.Scan(&chrtID, &item.TrackNumber, &item.Price, &item.RID, &item.Name, &item.Sale, &item.Size,
+			&item.TotalPrice, &nmID, &item.Brand, &item.Status); err != nil {
+			return "", err
+		}
+		item.ChrtID, item.NmID = uint64(chrtID), uint64(nmID)
+		o.Items = append(o.Items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(o)
+	if err != nil {
+		return "", err
+	}
+	var patch []patchOp
+	if err := json.Unmarshal(extra, &patch); err != nil {
+		return "", fmt.Errorf("storage: postgres: could not decode the extra fields of order %s: %w", orderUID, err)
+	}
+	if data, err = applyPatch(data, patch); err != nil {
+		return "", fmt.Errorf("storage: postgres: could not restore order %s: %w", orderUID, err)
+	}
+	return string(data), nil
+}
+
+// Backfill writes the orders that are missing in the normalized tables or have stale versions there.
+// The orders are processed in batches of the given size, every batch in its own transaction; the orders
+// that could not be parsed are logged and skipped. The number of the written orders is returned.
+// NB the write timeout of the storage is not applied since a batch takes much longer than a single write;
+// the backfill is limited only by the context.
+func (s *Storage) Backfill(ctx context.Context, batchSize int) (int, error) {
+	if !s.normalized {
+		return 0, errors.New("storage: postgres: normalized schema is not enabled")
+	}
+	if batchSize < 1 {
+		return 0, errors.New("batch size must be positive")
+	}
+	total, lastUID := 0, ""
+	for {
+		n, last, err := s.backfillBatch(ctx, lastUID, batchSize)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if last == "" {
+			return total, nil
+		}
+		lastUID = last
+	}
+}
+
+// backfillBatch writes the next batch of the stale orders with UIDs greater than the given one.
+// The UID of the last processed order is returned, empty if there are no more orders.
+func (s *Storage) backfillBatch(ctx context.Context, afterUID string, batchSize int) (int, string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, `SELECT o.uid, o.json_order, o.version FROM orders o
+		LEFT JOIN normalized.orders n ON n.uid = o.uid
+		WHERE o.uid > $1 AND (n.uid IS NULL OR n.version <> o.version)
+		ORDER BY o.uid LIMIT $2
+		FOR UPDATE OF o;`, afterUID, batchSize)
+	if err != nil {
+		return 0, "", err
+	}
+	var batch []storage.OrderDB
+	for rows.Next() {
+		var o storage.OrderDB
+		if err := rows.Scan(&o.OrderUID, &o.JSONOrder, &o.Version); err != nil {
+			rows.Close()
+			return 0, "", err
+		}
+		batch = append(batch, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, "", err
+	}
+	if len(batch) == 0 {
+		return 0, "", nil
+	}
+	n := 0
+	for _, o := range batch {
+		// the failed order must not abort the transaction
+		if _, err := tx.ExecContext(ctx, `SAVEPOINT backfill;`); err != nil {
+			return 0, "", err
+		}
+		if err := s.normalize(ctx, tx, o.OrderUID, o.JSONOrder, o.Version); err != nil {
+			log.Printf("storage: postgres: ERR: backfill: skipping order %s: %s", o.OrderUID, err)
+			if _, err := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT backfill;`); err != nil {
+				return 0, "", err
+			}
+			continue
+		}
+		n++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, "", err
+	}
+	return n, batch[len(batch)-1].OrderUID, nil
+}
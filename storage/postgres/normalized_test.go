@@ -0,0 +1,85 @@
+package postgres
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/wildberries-L0/internal/testorder"
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+func TestNormalizedSchema(t *testing.T) {
+	defer cleanOrdersTable(t)
+	ctx := context.Background()
+	pg, err := NewStorage(ctx, pgMockDSN, WithMode(ModeSync), WithNormalizedSchema())
+	require.NoError(t, err)
+	defer pg.Close()
+	data, err := os.ReadFile("../../model.json")
+	require.NoError(t, err)
+	order := string(data)
+	const uid = "b563feb7b2b84b6test"
+
+	t.Run("Store", func(t *testing.T) {
+		require.NoError(t, pg.Store(ctx, uid, order))
+		got, err := pg.GetNormalized(ctx, uid)
+		require.NoError(t, err)
+		assert.JSONEq(t, order, got, "the order must be reconstructed from the normalized tables")
+	})
+	t.Run("Unknown fields", func(t *testing.T) {
+		const uid = "normalized-unknown"
+		order := testorder.New(t, uid, map[string]interface{}{
+			"gift_wrap": true,
+			"oof_shard": nil,
+			"delivery":  map[string]interface{}{"name": "Test Testov", "floor": 3},
+			"items":     []interface{}{map[string]interface{}{"chrt_id": 1, "color": "red"}},
+		})
+		require.NoError(t, pg.Store(ctx, uid, string(order)))
+		jsonOrder, err := pg.Get(ctx, uid)
+		require.NoError(t, err)
+		got, err := pg.GetNormalized(ctx, uid)
+		require.NoError(t, err)
+		assert.JSONEq(t, jsonOrder, got, "the unknown fields must be restored")
+	})
+	t.Run("Store unparsable order", func(t *testing.T) {
+		err := pg.Store(ctx, "bad", `{"items": "none"}`)
+		assert.Error(t, err)
+		_, err = pg.Get(ctx, "bad")
+		assert.ErrorIs(t, err, storage.ErrNotFound, "the order must not be stored without its normalized rows")
+	})
+	t.Run("Erase PII", func(t *testing.T) {
+		_, err := pg.ErasePII(ctx, uid, storage.Erasure{Requester: "test", Reason: "test"})
+		require.NoError(t, err)
+		jsonOrder, err := pg.Get(ctx, uid)
+		require.NoError(t, err)
+		got, err := pg.GetNormalized(ctx, uid)
+		require.NoError(t, err)
+		assert.JSONEq(t, jsonOrder, got)
+	})
+	t.Run("Delete", func(t *testing.T) {
+		require.NoError(t, pg.Delete(ctx, uid))
+		_, err := pg.GetNormalized(ctx, uid)
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	})
+	t.Run("Backfill", func(t *testing.T) {
+		// the orders written without the normalized schema
+		plain, err := NewStorage(ctx, pgMockDSN, WithMode(ModeSync))
+		require.NoError(t, err)
+		defer plain.Close()
+		require.NoError(t, plain.Store(ctx, uid, order))
+		require.NoError(t, plain.Store(ctx, "bad", `{"items": "none"}`))
+
+		n, err := pg.Backfill(ctx, 1)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n, "the unparsable order must be skipped")
+		got, err := pg.GetNormalized(ctx, uid)
+		require.NoError(t, err)
+		assert.JSONEq(t, order, got)
+
+		n, err = pg.Backfill(ctx, 1)
+		require.NoError(t, err)
+		assert.Zero(t, n, "the normalized orders must not be written again")
+	})
+}
@@ -0,0 +1,201 @@
+package postgres
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// patchOp is the operation of the JSON Patch (RFC 6902) that restores the stored order from the one
+// rebuilt of models.Order. Only the add, replace and remove operations are used.
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Patch operations.
+const (
+	opAdd     = "add"
+	opReplace = "replace"
+	opRemove  = "remove"
+)
+
+// diffJSON returns the patch turning the rebuilt JSON document into the original one:
+// the fields unknown to the model, the fields missing in the original and the values the model
+// represents in another way (e.g. null or 1.0). The numbers are compared by their text.
+func diffJSON(original, rebuilt []byte) ([]patchOp, error) {
+	orig, err := decodeJSON(original)
+	if err != nil {
+		return nil, err
+	}
+	reb, err := decodeJSON(rebuilt)
+	if err != nil {
+		return nil, err
+	}
+	ops := make([]patchOp, 0)
+	if err := diffValues("", orig, reb, &ops); err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+func diffValues(path string, orig, rebuilt interface{}, ops *[]patchOp) error {
+	replace := func() error {
+		value, err := json.Marshal(orig)
+		if err != nil {
+			return err
+		}
+		*ops = append(*ops, patchOp{Op: opReplace, Path: path, Value: value})
+		return nil
+	}
+	switch o := orig.(type) {
+	case map[string]interface{}:
+		r, ok := rebuilt.(map[string]interface{})
+		if !ok {
+			return replace()
+		}
+		for _, k := range sortedKeys(o) {
+			p := path + "/" + escapePointer(k)
+			rv, ok := r[k]
+			if ok {
+				if err := diffValues(p, o[k], rv, ops); err != nil {
+					return err
+				}
+				continue
+			}
+			value, err := json.Marshal(o[k])
+			if err != nil {
+				return err
+			}
+			*ops = append(*ops, patchOp{Op: opAdd, Path: p, Value: value})
+		}
+		for _, k := range sortedKeys(r) {
+			if _, ok := o[k]; !ok {
+				*ops = append(*ops, patchOp{Op: opRemove, Path: path + "/" + escapePointer(k)})
+			}
+		}
+		return nil
+	case []interface{}:
+		r, ok := rebuilt.([]interface{})
+		if !ok || len(r) != len(o) {
+			return replace()
+		}
+		for i := range o {
+			if err := diffValues(path+"/"+strconv.Itoa(i), o[i], r[i], ops); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if !reflect.DeepEqual(orig, rebuilt) {
+		return replace()
+	}
+	return nil
+}
+
+// applyPatch applies the patch made by diffJSON to the JSON document.
+func applyPatch(doc []byte, ops []patchOp) ([]byte, error) {
+	if len(ops) == 0 {
+		return doc, nil
+	}
+	v, err := decodeJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range ops {
+		if op.Op != opAdd && op.Op != opReplace && op.Op != opRemove {
+			return nil, fmt.Errorf("unsupported patch operation %q", op.Op)
+		}
+		var tokens []string
+		if op.Path != "" {
+			if !strings.HasPrefix(op.Path, "/") {
+				return nil, fmt.Errorf("invalid patch path %q", op.Path)
+			}
+			tokens = strings.Split(op.Path[1:], "/")
+			for i := range tokens {
+				tokens[i] = unescapePointer(tokens[i])
+			}
+		}
+		if v, err = applyOp(v, tokens, op); err != nil {
+			return nil, fmt.Errorf("could not apply patch operation %s %q: %w", op.Op, op.Path, err)
+		}
+	}
+	return json.Marshal(v)
+}
+
+func applyOp(doc interface{}, tokens []string, op patchOp) (interface{}, error) {
+	if len(tokens) == 0 {
+		if op.Op == opRemove {
+			return nil, errors.New("could not remove the document")
+		}
+		return decodeJSON(op.Value)
+	}
+	switch c := doc.(type) {
+	case map[string]interface{}:
+		k := tokens[0]
+		if len(tokens) == 1 && op.Op == opRemove {
+			delete(c, k)
+			return c, nil
+		}
+		child, ok := c[k]
+		if !ok && len(tokens) > 1 {
+			return nil, fmt.Errorf("no field %q", k)
+		}
+		v, err := applyOp(child, tokens[1:], op)
+		if err != nil {
+			return nil, err
+		}
+		c[k] = v
+		return c, nil
+	case []interface{}:
+		i, err := strconv.Atoi(tokens[0])
+		if err != nil || i < 0 || i >= len(c) {
+			return nil, fmt.Errorf("no element %q", tokens[0])
+		}
+		if len(tokens) == 1 && op.Op == opRemove {
+			return nil, errors.New("could not remove the array element")
+		}
+		v, err := applyOp(c[i], tokens[1:], op)
+		if err != nil {
+			return nil, err
+		}
+		c[i] = v
+		return c, nil
+	}
+	return nil, errors.New("the path does not exist")
+}
+
+// decodeJSON decodes the JSON document keeping the numbers as they are.
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escapePointer and unescapePointer encode the reference token of the JSON Pointer (RFC 6901).
+func escapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+func unescapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+}
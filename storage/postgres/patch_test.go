@@ -0,0 +1,72 @@
+package postgres
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/wildberries-L0/internal/testorder"
+	"github.com/vanamelnik/wildberries-L0/models"
+)
+
+func TestPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes map[string]interface{}
+		remove  []string // the fields removed from the order
+	}{
+		{name: "Modeled order"},
+		{
+			name: "Unknown fields",
+			changes: map[string]interface{}{
+				"gift_wrap": true,
+				"a/b~c":     map[string]interface{}{"nested": []int{1, 2}},
+				"delivery":  map[string]interface{}{"name": "Test Testov", "floor": 3},
+				"items":     []interface{}{map[string]interface{}{"chrt_id": 1, "color": "red"}},
+			},
+		},
+		{
+			name:    "Null and missing fields",
+			changes: map[string]interface{}{"oof_shard": nil, "payment": nil},
+			remove:  []string{"entry", "delivery"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			original := testorder.New(t, "patch", tc.changes)
+			if tc.remove != nil {
+				var m map[string]interface{}
+				require.NoError(t, json.Unmarshal(original, &m))
+				for _, field := range tc.remove {
+					delete(m, field)
+				}
+				var err error
+				original, err = json.Marshal(m)
+				require.NoError(t, err)
+			}
+			var o models.Order
+			require.NoError(t, json.Unmarshal(original, &o))
+			rebuilt, err := json.Marshal(o)
+			require.NoError(t, err)
+
+			patch, err := diffJSON(original, rebuilt)
+			require.NoError(t, err)
+			if tc.changes == nil {
+				assert.Empty(t, patch, "the modeled order must be rebuilt as it is")
+			}
+			data, err := json.Marshal(patch)
+			require.NoError(t, err)
+			var stored []patchOp
+			require.NoError(t, json.Unmarshal(data, &stored))
+			restored, err := applyPatch(rebuilt, stored)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(original), string(restored))
+		})
+	}
+
+	_, err := applyPatch([]byte(`{}`), []patchOp{{Op: "move", Path: "/a"}})
+	assert.Error(t, err, "the unsupported operation must fail")
+	_, err = applyPatch([]byte(`{}`), []patchOp{{Op: opReplace, Path: "/a/b", Value: json.RawMessage(`1`)}})
+	assert.Error(t, err, "the missing path must fail")
+}
@@ -1,57 +1,177 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
-	_ "embed"
 	"errors"
-	"log"
+	"fmt"
 	"sync"
+	"time"
 
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
 	_ "github.com/jackc/pgx/v4/stdlib"
 	"github.com/vanamelnik/wildberries-L0/storage"
 )
 
-const storeChSize = 1000 // the size of storeCh buffer
+const (
+	storeChSize = 1000 // the size of storeCh buffer
+
+	defaultReadTimeout  = 5 * time.Second
+	defaultWriteTimeout = 5 * time.Second
+)
+
+// Storing modes.
+const (
+	// ModeAsync makes Store put the order to the queue and return immediately.
+	// The order is written to the database by the background worker and the errors are only logged.
+	ModeAsync Mode = iota
+	// ModeSync makes Store block until the order is committed to the database
+	// and return the real error if the order could not be stored.
+	ModeSync
+)
 
 type (
 	// Storage is an implementation of storage.Storage using Postgresql db engine.
-	// Saving the orders works in async mode.
+	// Saving the orders works in async mode unless ModeSync is provided.
 	Storage struct {
-		db      *sql.DB
-		storeCh chan storage.OrderDB
-		stopCh  chan struct{}
-		wg      *sync.WaitGroup
+		db          *sql.DB
+		databaseURI string
+		mode        Mode
+		storeCh     chan storage.OrderDB
+		stopCh      chan struct{}
+		wg          *sync.WaitGroup
+
+		readTimeout  time.Duration
+		writeTimeout time.Duration
+
+		retryPolicy RetryPolicy
+		failureHook FailureHook
+		counters    counters
+
+		batchSize  int
+		batchDelay time.Duration
+
+		// normalized is set if the orders are written also to the normalized schema, see WithNormalizedSchema.
+		normalized bool
+		// autoMigrate is set if the pending migrations are applied on start, see WithAutoMigrate.
+		autoMigrate bool
+		// metrics are registered by WithMetrics.
+		metrics storageMetrics
 	}
+
+	StorageOpt func(s *Storage) error
+
+	// Mode defines how Store method persists the orders.
+	Mode int
 )
 
 var _ storage.Storage = (*Storage)(nil)
 
-//go:embed schema.sql
-var queryCreate string
-
-// NewStorage connects to the postgres database and runs the worker that stores all incoming orders.
-func NewStorage(databaseURI string) (*Storage, error) {
+// NewStorage connects to the postgres database, migrates its schema (see Migrator) and runs the worker
+// that stores all incoming orders. The context is used only for connecting and preparing the database.
+func NewStorage(ctx context.Context, databaseURI string, opts ...StorageOpt) (*Storage, error) {
+	s := &Storage{
+		storeCh:      make(chan storage.OrderDB, storeChSize),
+		stopCh:       make(chan struct{}),
+		wg:           &sync.WaitGroup{},
+		readTimeout:  defaultReadTimeout,
+		writeTimeout: defaultWriteTimeout,
+		retryPolicy:  DefaultRetryPolicy,
+		batchSize:    defaultBatchSize,
+		batchDelay:   defaultBatchDelay,
+		autoMigrate:  true,
+		metrics:      newStorageMetrics(),
+	}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, fmt.Errorf("storage: postgres: could not apply option: %w", err)
+		}
+	}
 	db, err := sql.Open("pgx", databaseURI)
 	if err != nil {
 		return nil, err
 	}
-	if err := db.Ping(); err != nil {
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
 		return nil, err
 	}
-	if _, err := db.Exec(queryCreate); err != nil {
+	if err := s.migrate(ctx, db); err != nil {
+		db.Close()
 		return nil, err
 	}
-	s := &Storage{
-		db:      db,
-		storeCh: make(chan storage.OrderDB, storeChSize),
-		stopCh:  make(chan struct{}),
-		wg:      &sync.WaitGroup{},
+	s.db = db
+	s.databaseURI = databaseURI
+	if s.mode == ModeAsync {
+		s.wg.Add(1)
+		go s.storer(s.stopCh)
 	}
-	s.wg.Add(1)
-	go s.storer()
 	return s, nil
 }
 
+// WithMode sets the storing mode. The default mode is ModeAsync.
+func WithMode(m Mode) StorageOpt {
+	return func(s *Storage) error {
+		if m != ModeAsync && m != ModeSync {
+			return fmt.Errorf("unknown storing mode %d", m)
+		}
+		s.mode = m
+		return nil
+	}
+}
+
+// WithAutoMigrate sets whether the pending schema migrations are applied by NewStorage. It is enabled by default;
+// if disabled, NewStorage returns ErrSchemaOutdated unless the schema is up to date.
+func WithAutoMigrate(enabled bool) StorageOpt {
+	return func(s *Storage) error {
+		s.autoMigrate = enabled
+		return nil
+	}
+}
+
+// WithReadTimeout sets the timeout for every reading query. Zero value means no timeout
+// except the deadline of the context provided by the caller.
+func WithReadTimeout(d time.Duration) StorageOpt {
+	return func(s *Storage) error {
+		if d < 0 {
+			return errors.New("negative read timeout")
+		}
+		s.readTimeout = d
+		return nil
+	}
+}
+
+// WithWriteTimeout sets the timeout for every writing query. Zero value means no timeout.
+func WithWriteTimeout(d time.Duration) StorageOpt {
+	return func(s *Storage) error {
+		if d < 0 {
+			return errors.New("negative write timeout")
+		}
+		s.writeTimeout = d
+		return nil
+	}
+}
+
+// migrate applies the pending migrations or checks that there are none if the automatic migration is disabled.
+func (s *Storage) migrate(ctx context.Context, db *sql.DB) error {
+	m, err := newMigrator(db)
+	if err != nil {
+		return err
+	}
+	if s.autoMigrate {
+		_, err := m.Up(ctx)
+		return err
+	}
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if pending {
+		return ErrSchemaOutdated
+	}
+	return nil
+}
+
 // Close stops the worker and closes the db connection.
 func (s *Storage) Close() error {
 	if s.stopCh != nil {
@@ -63,9 +183,11 @@ func (s *Storage) Close() error {
 }
 
 // Get implements storage.Storage interface.
-func (s *Storage) Get(orderUID string) (string, error) {
+func (s *Storage) Get(ctx context.Context, orderUID string) (string, error) {
+	ctx, cancel := withTimeout(ctx, s.readTimeout)
+	defer cancel()
 	var order string
-	err := s.db.QueryRow(`SELECT json_order FROM orders WHERE uid = $1;`, orderUID).Scan(&order)
+	err := s.db.QueryRowContext(ctx, `SELECT json_order FROM orders WHERE uid = $1;`, orderUID).Scan(&order)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return "", storage.ErrNotFound
@@ -75,50 +197,145 @@ func (s *Storage) Get(orderUID string) (string, error) {
 	return order, nil
 }
 
+// GetRecord implements storage.Storage interface.
+func (s *Storage) GetRecord(ctx context.Context, orderUID string) (storage.OrderDB, error) {
+	ctx, cancel := withTimeout(ctx, s.readTimeout)
+	defer cancel()
+	o := storage.OrderDB{OrderUID: orderUID}
+	err := s.db.QueryRowContext(ctx, `SELECT json_order, version FROM orders WHERE uid = $1;`, orderUID).
+		Scan(&o.JSONOrder, &o.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.OrderDB{}, storage.ErrNotFound
+		}
+		return storage.OrderDB{}, err
+	}
+	return o, nil
+}
+
 // GetAll implements storage.Storage interface.
-func (s *Storage) GetAll() ([]storage.OrderDB, error) {
+func (s *Storage) GetAll(ctx context.Context) ([]storage.OrderDB, error) {
+	ctx, cancel := withTimeout(ctx, s.readTimeout)
+	defer cancel()
 	orders := make([]storage.OrderDB, 0)
-	rows, err := s.db.Query("SELECT uid, json_order FROM orders;")
+	rows, err := s.db.QueryContext(ctx, "SELECT uid, json_order, version FROM orders;")
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var o storage.OrderDB
-		if err := rows.Scan(&o.OrderUID, &o.JSONOrder); err != nil {
+		if err := rows.Scan(&o.OrderUID, &o.JSONOrder, &o.Version); err != nil {
 			return nil, err
 		}
 		orders = append(orders, o)
 	}
-	return orders, nil
+	return orders, rows.Err()
+}
+
+// Update implements storage.Storage interface.
+// NB Update always works synchronously regardless of the storing mode, so in ModeAsync
+// the order could be not yet inserted by the storer.
+func (s *Storage) Update(ctx context.Context, orderUID, jsonOrder string, version int64) (int64, error) {
+	ctx, cancel := withTimeout(ctx, s.writeTimeout)
+	defer cancel()
+	var newVersion int64
+	err := s.write(ctx, opUpdate, func(q queryer) error {
+		err := q.QueryRowContext(ctx, `UPDATE orders SET json_order = $2, version = version + 1
+			WHERE uid = $1 AND version = $3 RETURNING version;`, orderUID, jsonOrder, version).Scan(&newVersion)
+		if err != nil {
+			return err
+		}
+		return s.normalize(ctx, q, orderUID, jsonOrder, newVersion)
+	})
+	if err == nil {
+		return newVersion, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	// find out why the order has not been updated
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM orders WHERE uid = $1);`, orderUID).Scan(&exists); err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, storage.ErrNotFound
+	}
+	return 0, storage.ErrVersionConflict
+}
+
+// Upsert implements storage.Storage interface.
+// NB Upsert always works synchronously regardless of the storing mode.
+func (s *Storage) Upsert(ctx context.Context, orderUID, jsonOrder string) (int64, error) {
+	ctx, cancel := withTimeout(ctx, s.writeTimeout)
+	defer cancel()
+	var version int64
+	err := s.write(ctx, opUpsert, func(q queryer) error {
+		err := q.QueryRowContext(ctx, `INSERT INTO orders (uid, json_order) VALUES ($1, $2)
+			ON CONFLICT (uid) DO UPDATE SET json_order = EXCLUDED.json_order, version = orders.version + 1
+			WHERE orders.json_order IS DISTINCT FROM EXCLUDED.json_order
+			RETURNING version;`, orderUID, jsonOrder).Scan(&version)
+		if errors.Is(err, sql.ErrNoRows) {
+			// the same order is already stored (e.g. the message is redelivered)
+			return q.QueryRowContext(ctx, `SELECT version FROM orders WHERE uid = $1;`, orderUID).Scan(&version)
+		}
+		if err != nil {
+			return err
+		}
+		return s.normalize(ctx, q, orderUID, jsonOrder, version)
+	})
+	return version, err
 }
 
 // Store implements storage.Storage interface.
-// NB Store method works in async mode and only logs errors that have occured.
-func (s *Storage) Store(orderUID, order string) error {
-	s.storeCh <- storage.OrderDB{
+// NB In ModeAsync Store method only puts the order to the queue, the errors that have occured
+// while storing are only logged. The context limits the time of waiting for a free place in the queue.
+// In ModeSync Store returns after the order is committed; storage.ErrAlreadyExists is returned
+// if the order with the same UID is already in the database. The errors are not retried in ModeSync:
+// it is up to the caller to store the order again.
+func (s *Storage) Store(ctx context.Context, orderUID, order string) error {
+	o := storage.OrderDB{
 		OrderUID:  orderUID,
 		JSONOrder: order,
 	}
-	return nil
+	if s.mode == ModeSync {
+		return s.insert(ctx, o)
+	}
+	select {
+	case s.storeCh <- o:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-// storer is the worker function that listens to the storeCh channel and stores
-// all incoming orders to the database.
-func (s *Storage) storer() {
-	log.Println("storage: postgres: storer started")
-	for {
-		select {
-		case o := <-s.storeCh:
-			if _, err := s.db.Exec(`INSERT INTO orders (uid, json_order) VALUES ($1, $2)`, o.OrderUID, o.JSONOrder); err != nil {
-				log.Printf("storage: postgres: ERR: could not store the order %s: %s", o.OrderUID, err)
-			} else {
-				log.Printf("storage: postgres: order %s sucessfully stored", o.OrderUID)
-			}
-		case <-s.stopCh:
-			log.Println("storage: postgres: storer stopped")
-			s.wg.Done()
-			return
+// insert writes the order to the database within the write timeout.
+func (s *Storage) insert(ctx context.Context, o storage.OrderDB) error {
+	ctx, cancel := withTimeout(ctx, s.writeTimeout)
+	defer cancel()
+	err := s.write(ctx, opInsert, func(q queryer) error {
+		if _, err := q.ExecContext(ctx, `INSERT INTO orders (uid, json_order) VALUES ($1, $2)`, o.OrderUID, o.JSONOrder); err != nil {
+			return err
 		}
+		return s.normalize(ctx, q, o.OrderUID, o.JSONOrder, 1)
+	})
+	if isUniqueViolation(err) {
+		return storage.ErrAlreadyExists
+	}
+	return err
+}
+
+// isUniqueViolation reports whether the error is caused by the unique constraint violation.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation
+}
+
+// withTimeout returns a copy of the context with the timeout applied if the timeout is set.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, timeout)
 }
@@ -35,7 +35,7 @@ func TestPostgres(t *testing.T) {
 	}
 	t.Run("Store 1000 records", func(t *testing.T) {
 		for _, o := range fixtures {
-			assert.NoError(t, pgMockStorage.Store(o.OrderUID, o.JSONOrder))
+			assert.NoError(t, pgMockStorage.Store(context.Background(), o.OrderUID, o.JSONOrder))
 		}
 		start := time.Now()
 		wg := new(sync.WaitGroup)
@@ -62,7 +62,7 @@ func TestPostgres(t *testing.T) {
 		t.Logf("done in %v", time.Since(start))
 	})
 	t.Run("Get all", func(t *testing.T) {
-		got, err := pgMockStorage.GetAll()
+		got, err := pgMockStorage.GetAll(context.Background())
 		assert.NoError(t, err)
 		require.Equal(t, 1000, len(got))
 		sort.Slice(got, func(i, j int) bool {
@@ -80,7 +80,7 @@ func TestPostgres(t *testing.T) {
 	t.Run("Test Get()", func(t *testing.T) {
 		require.Equal(t, 1000, numOrders(t))
 		for i := range fixtures {
-			order, err := pgMockStorage.Get(fmt.Sprint(i + 1))
+			order, err := pgMockStorage.Get(context.Background(), fmt.Sprint(i+1))
 			assert.NoError(t, err)
 			assert.JSONEq(t, fixtures[i].JSONOrder, order)
 		}
@@ -89,7 +89,7 @@ func TestPostgres(t *testing.T) {
 
 func TestGetError(t *testing.T) {
 	t.Run("Get non-existing order", func(t *testing.T) {
-		_, err := pgMockStorage.Get("nihil")
+		_, err := pgMockStorage.Get(context.Background(), "nihil")
 		assert.ErrorIs(t, err, storage.ErrNotFound)
 	})
 }
@@ -100,3 +100,81 @@ func numOrders(t *testing.T) int {
 	require.NoError(t, err)
 	return res
 }
+
+func TestContextCancel(t *testing.T) {
+	t.Run("Get with canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := pgMockStorage.Get(ctx, "1")
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+	t.Run("GetAll with exceeded deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+		defer cancel()
+		<-ctx.Done()
+		_, err := pgMockStorage.GetAll(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestSyncMode(t *testing.T) {
+	defer cleanOrdersTable(t)
+	pg, err := NewStorage(context.Background(), pgMockDSN, WithMode(ModeSync))
+	require.NoError(t, err)
+	defer pg.Close()
+	ctx := context.Background()
+	t.Run("Store is committed on return", func(t *testing.T) {
+		require.NoError(t, pg.Store(ctx, "sync-1", `{"id":1}`))
+		got, err := pg.Get(ctx, "sync-1")
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"id":1}`, got)
+	})
+	t.Run("Store duplicate", func(t *testing.T) {
+		err := pg.Store(ctx, "sync-1", `{"id":2}`)
+		assert.ErrorIs(t, err, storage.ErrAlreadyExists)
+	})
+	t.Run("Store invalid json", func(t *testing.T) {
+		err := pg.Store(ctx, "sync-2", `{"id":`)
+		assert.Error(t, err)
+		_, err = pg.Get(ctx, "sync-2")
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	defer cleanOrdersTable(t)
+	ctx := context.Background()
+	v, err := pgMockStorage.Upsert(ctx, "upd-1", `{"id":1}`)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), v)
+	t.Run("Update", func(t *testing.T) {
+		v, err := pgMockStorage.Update(ctx, "upd-1", `{"id":1,"v":2}`, 1)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), v)
+		o, err := pgMockStorage.GetRecord(ctx, "upd-1")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), o.Version)
+		assert.JSONEq(t, `{"id":1,"v":2}`, o.JSONOrder)
+	})
+	t.Run("Update stale version", func(t *testing.T) {
+		_, err := pgMockStorage.Update(ctx, "upd-1", `{"id":1,"v":3}`, 1)
+		assert.ErrorIs(t, err, storage.ErrVersionConflict)
+	})
+	t.Run("Update non-existing order", func(t *testing.T) {
+		_, err := pgMockStorage.Update(ctx, "nihil", `{}`, 1)
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	})
+	t.Run("Upsert existing order", func(t *testing.T) {
+		v, err := pgMockStorage.Upsert(ctx, "upd-1", `{"id":1,"v":3}`)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), v)
+		got, err := pgMockStorage.Get(ctx, "upd-1")
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"id":1,"v":3}`, got)
+	})
+	t.Run("Upsert the same order", func(t *testing.T) {
+		v, err := pgMockStorage.Upsert(ctx, "upd-1", `{"v":3, "id":1}`)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), v, "the version of the same order must not be changed")
+	})
+}
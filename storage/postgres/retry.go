@@ -0,0 +1,162 @@
+package postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+type (
+	// RetryPolicy defines how the storer retries the transient failures.
+	// The delay before the n-th retry is BaseDelay*2^(n-1) capped by MaxDelay,
+	// the random jitter of up to a half of the delay is subtracted from it.
+	RetryPolicy struct {
+		MaxAttempts int // the number of attempts including the first one
+		BaseDelay   time.Duration
+		MaxDelay    time.Duration
+	}
+
+	// FailureHook is called by the storer for every order that could not be stored:
+	// either a permanent error occurred or the retry attempts were exhausted.
+	FailureHook func(o storage.OrderDB, err error)
+
+	// Stats contains the counters of the storer.
+	Stats struct {
+		Retries uint64 // the number of retried inserts
+		GiveUps uint64 // the number of orders given up after exhausting the attempts
+		Failed  uint64 // the number of orders failed with a permanent error
+	}
+
+	// counters are updated atomically by the storer.
+	counters struct {
+		retries uint64
+		giveUps uint64
+		failed  uint64
+	}
+)
+
+// DefaultRetryPolicy is used by the storer unless another policy is provided.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    5 * time.Second,
+}
+
+// WithRetryPolicy sets the retry policy of the storer.
+func WithRetryPolicy(p RetryPolicy) StorageOpt {
+	return func(s *Storage) error {
+		if p.MaxAttempts < 1 {
+			return errors.New("retry policy: at least one attempt must be allowed")
+		}
+		if p.BaseDelay < 0 || p.MaxDelay < p.BaseDelay {
+			return errors.New("retry policy: incorrect delays")
+		}
+		s.retryPolicy = p
+		return nil
+	}
+}
+
+// WithFailureHook registers the hook that is called by the storer for every order that could not be stored.
+func WithFailureHook(hook FailureHook) StorageOpt {
+	return func(s *Storage) error {
+		s.failureHook = hook
+		return nil
+	}
+}
+
+// Stats returns the current values of the storer counters.
+func (s *Storage) Stats() Stats {
+	return Stats{
+		Retries: atomic.LoadUint64(&s.counters.retries),
+		GiveUps: atomic.LoadUint64(&s.counters.giveUps),
+		Failed:  atomic.LoadUint64(&s.counters.failed),
+	}
+}
+
+// insertWithRetry inserts the order retrying the transient failures according to the retry policy.
+// If the order could not be stored, it is passed to the failure hook.
+func (s *Storage) insertWithRetry(o storage.OrderDB, stop <-chan struct{}) error {
+	err := s.retry(stop, func() error { return s.insert(context.Background(), o) })
+	if err != nil {
+		s.fail(o, err)
+	}
+	return err
+}
+
+// retry calls fn until it succeeds, returns a permanent error or the attempts are exhausted.
+// The retrying is interrupted if the stop channel is closed.
+func (s *Storage) retry(stop <-chan struct{}, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isTransient(err) || attempt >= s.retryPolicy.MaxAttempts {
+			return err
+		}
+		atomic.AddUint64(&s.counters.retries, 1)
+		timer := time.NewTimer(s.retryPolicy.delay(attempt))
+		select {
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// fail updates the counters and calls the failure hook if it is registered.
+func (s *Storage) fail(o storage.OrderDB, err error) {
+	if isTransient(err) {
+		atomic.AddUint64(&s.counters.giveUps, 1)
+	} else {
+		atomic.AddUint64(&s.counters.failed, 1)
+	}
+	if s.failureHook != nil {
+		s.failureHook(o, err)
+	}
+}
+
+// delay returns the delay before the next attempt after the given number of failed attempts.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.MaxDelay
+	// the maximal delay is shifted instead of the base one, so the comparison could not overflow
+	if shift := attempt - 1; shift >= 0 && shift < 63 && p.BaseDelay <= p.MaxDelay>>shift {
+		d = p.BaseDelay << shift
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d - time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+// isTransient reports whether the error is caused by a temporary condition (lost connection,
+// timeout, serialization failure, server shutdown etc.) so the operation could be retried.
+// The errors returned by the postgres server for the query itself (constraint violations,
+// incorrect data) are permanent.
+func isTransient(err error) bool {
+	if errors.Is(err, storage.ErrAlreadyExists) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgerrcode.IsConnectionException(pgErr.Code) ||
+			pgerrcode.IsTransactionRollback(pgErr.Code) ||
+			pgerrcode.IsInsufficientResources(pgErr.Code) ||
+			pgerrcode.IsOperatorIntervention(pgErr.Code) ||
+			pgerrcode.IsSystemError(pgErr.Code)
+	}
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &netErr) ||
+		pgconn.SafeToRetry(err) ||
+		pgconn.Timeout(err)
+}
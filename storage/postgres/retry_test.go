@@ -0,0 +1,91 @@
+package postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+func TestIsTransient(t *testing.T) {
+	tc := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{"unique violation", &pgconn.PgError{Code: pgerrcode.UniqueViolation}, false},
+		{"already exists", fmt.Errorf("wrapped: %w", storage.ErrAlreadyExists), false},
+		{"invalid json", &pgconn.PgError{Code: pgerrcode.InvalidTextRepresentation}, false},
+		{"connection failure", &pgconn.PgError{Code: pgerrcode.ConnectionFailure}, true},
+		{"serialization failure", &pgconn.PgError{Code: pgerrcode.SerializationFailure}, true},
+		{"admin shutdown", &pgconn.PgError{Code: pgerrcode.AdminShutdown}, true},
+		{"too many connections", &pgconn.PgError{Code: pgerrcode.TooManyConnections}, true},
+		{"bad connection", driver.ErrBadConn, true},
+		{"unexpected EOF", fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true},
+		{"timeout", context.DeadlineExceeded, true},
+		{"unknown error", errors.New("something went wrong"), false},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.transient, isTransient(tt.err))
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+	for i, max := range expected {
+		for j := 0; j < 100; j++ {
+			d := p.delay(i + 1)
+			require.LessOrEqual(t, d, max)
+			require.GreaterOrEqual(t, d, max/2)
+		}
+	}
+	assert.LessOrEqual(t, p.delay(100), time.Second, "the delay must not overflow")
+
+	p = RetryPolicy{MaxAttempts: 100, BaseDelay: 5 * time.Second, MaxDelay: time.Minute}
+	for attempt := 1; attempt <= 100; attempt++ {
+		require.GreaterOrEqual(t, p.delay(attempt), 2500*time.Millisecond,
+			"the delay must not overflow at attempt %d", attempt)
+	}
+}
+
+func TestStorerFailureHook(t *testing.T) {
+	defer cleanOrdersTable(t)
+	failed := make(chan storage.OrderDB, 1)
+	pg, err := NewStorage(context.Background(), pgMockDSN,
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}),
+		WithFailureHook(func(o storage.OrderDB, err error) { failed <- o }),
+	)
+	require.NoError(t, err)
+	defer pg.Close()
+	ctx := context.Background()
+	require.NoError(t, pg.Store(ctx, "hook-1", `{"id":1}`))
+	require.NoError(t, pg.Store(ctx, "hook-1", `{"id":2}`))
+	select {
+	case o := <-failed:
+		assert.Equal(t, `{"id":2}`, o.JSONOrder)
+	case <-time.After(5 * time.Second):
+		t.Fatal("failure hook is not called")
+	}
+	stats := pg.Stats()
+	assert.Equal(t, uint64(1), stats.Failed)
+	assert.Equal(t, uint64(0), stats.Retries, "permanent errors must not be retried")
+}
@@ -0,0 +1,145 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+const (
+	defaultBatchSize  = 100
+	defaultBatchDelay = 10 * time.Millisecond
+	maxBatchSize      = 1000
+)
+
+// WithBatching sets the maximal number of orders the storer writes with a single INSERT
+// and the maximal time of waiting for the batch to be filled. The batch size of 1 means
+// every order is inserted separately.
+func WithBatching(size int, maxDelay time.Duration) StorageOpt {
+	return func(s *Storage) error {
+		if size < 1 || size > maxBatchSize {
+			return fmt.Errorf("batch size must be in range 1..%d", maxBatchSize)
+		}
+		if maxDelay < 0 {
+			return errors.New("negative batch delay")
+		}
+		s.batchSize = size
+		s.batchDelay = maxDelay
+		return nil
+	}
+}
+
+// storer is the worker function that listens to the storeCh channel and stores
+// all incoming orders to the database. The orders are collected to batches.
+// The transient errors are retried according to the retry policy,
+// the orders that could not be stored are passed to the failure hook.
+func (s *Storage) storer(stop <-chan struct{}) {
+	log.Println("storage: postgres: storer started")
+	for {
+		select {
+		case o := <-s.storeCh:
+			s.storeBatch(s.collectBatch(o, stop), stop)
+		case <-stop:
+			log.Println("storage: postgres: storer stopped")
+			s.wg.Done()
+			return
+		}
+	}
+}
+
+// collectBatch reads the orders from storeCh until the batch is full or the batch delay is expired.
+func (s *Storage) collectBatch(first storage.OrderDB, stop <-chan struct{}) []storage.OrderDB {
+	batch := make([]storage.OrderDB, 1, s.batchSize)
+	batch[0] = first
+	if s.batchSize == 1 {
+		return batch
+	}
+	if s.batchDelay == 0 {
+		// take only the orders already waiting in the queue
+		for len(batch) < s.batchSize {
+			select {
+			case o := <-s.storeCh:
+				batch = append(batch, o)
+			default:
+				return batch
+			}
+		}
+		return batch
+	}
+	timer := time.NewTimer(s.batchDelay)
+	defer timer.Stop()
+	for len(batch) < s.batchSize {
+		select {
+		case o := <-s.storeCh:
+			batch = append(batch, o)
+		case <-timer.C:
+			return batch
+		case <-stop:
+			return batch
+		}
+	}
+	return batch
+}
+
+// storeBatch writes the batch with a single INSERT. If the batch is rejected with a permanent error
+// (e.g. one of the orders already exists), the orders are inserted one by one to isolate the bad ones.
+func (s *Storage) storeBatch(batch []storage.OrderDB, stop <-chan struct{}) {
+	if len(batch) > 1 {
+		err := s.retry(stop, func() error { return s.insertBatch(context.Background(), batch) })
+		if err == nil {
+			log.Printf("storage: postgres: %d orders sucessfully stored", len(batch))
+			return
+		}
+		if isTransient(err) {
+			log.Printf("storage: postgres: ERR: could not store the batch of %d orders: %s", len(batch), err)
+			for _, o := range batch {
+				s.fail(o, err)
+			}
+			return
+		}
+		log.Printf("storage: postgres: the batch of %d orders is rejected, storing one by one: %s", len(batch), err)
+	}
+	for _, o := range batch {
+		if err := s.insertWithRetry(o, stop); err != nil {
+			log.Printf("storage: postgres: ERR: could not store the order %s: %s", o.OrderUID, err)
+		} else {
+			log.Printf("storage: postgres: order %s sucessfully stored", o.OrderUID)
+		}
+	}
+}
+
+// insertBatch writes all the orders with a single multi-row INSERT within the write timeout.
+func (s *Storage) insertBatch(ctx context.Context, batch []storage.OrderDB) error {
+	ctx, cancel := withTimeout(ctx, s.writeTimeout)
+	defer cancel()
+	query := strings.Builder{}
+	query.WriteString(`INSERT INTO orders (uid, json_order) VALUES `)
+	args := make([]interface{}, 0, len(batch)*2)
+	for i, o := range batch {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		fmt.Fprintf(&query, "($%d, $%d)", 2*i+1, 2*i+2)
+		args = append(args, o.OrderUID, o.JSONOrder)
+	}
+	err := s.write(ctx, opInsertBatch, func(q queryer) error {
+		if _, err := q.ExecContext(ctx, query.String(), args...); err != nil {
+			return err
+		}
+		for _, o := range batch {
+			if err := s.normalize(ctx, q, o.OrderUID, o.JSONOrder, 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if isUniqueViolation(err) {
+		return storage.ErrAlreadyExists
+	}
+	return err
+}
@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+func TestStoreBatchFallback(t *testing.T) {
+	defer cleanOrdersTable(t)
+	failed := make(chan storage.OrderDB, 10)
+	pg, err := NewStorage(context.Background(), pgMockDSN,
+		WithBatching(10, time.Second),
+		WithFailureHook(func(o storage.OrderDB, err error) { failed <- o }),
+	)
+	require.NoError(t, err)
+	defer pg.Close()
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		order := fmt.Sprintf(`{"id":%d}`, i)
+		if i == 5 {
+			order = `{"id":` // invalid json makes the whole batch fail
+		}
+		require.NoError(t, pg.Store(ctx, fmt.Sprint(i), order))
+	}
+	select {
+	case o := <-failed:
+		assert.Equal(t, "5", o.OrderUID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("failure hook is not called")
+	}
+	require.Eventually(t, func() bool { return numOrders(t) == 9 }, 5*time.Second, 50*time.Millisecond,
+		"all the valid orders must be stored")
+}
+
+// BenchmarkStorer compares storing the orders one by one with storing them in batches.
+func BenchmarkStorer(b *testing.B) {
+	for _, size := range []int{1, 10, 100, 1000} {
+		b.Run(fmt.Sprintf("batch size %d", size), func(b *testing.B) {
+			pg, err := NewStorage(context.Background(), pgMockDSN, WithBatching(size, 10*time.Millisecond))
+			require.NoError(b, err)
+			defer pg.Close()
+			ctx := context.Background()
+			cleanOrdersTable(b)
+			defer cleanOrdersTable(b)
+			prefix := fmt.Sprintf("bench-%d-", size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, pg.Store(ctx, fmt.Sprintf("%s%d", prefix, i), `{"data":"benchmark"}`))
+			}
+			deadline := time.Now().Add(time.Minute)
+			for {
+				var n int
+				require.NoError(b, pg.db.QueryRow(`SELECT COUNT(*) FROM orders WHERE uid LIKE $1;`, prefix+"%").Scan(&n))
+				if n >= b.N {
+					break
+				}
+				if time.Now().After(deadline) {
+					b.Fatalf("only %d of %d orders are stored", n, b.N)
+				}
+				time.Sleep(time.Millisecond)
+			}
+			b.StopTimer()
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+var _ storage.Streamer = (*Storage)(nil)
+
+// Stream implements storage.Streamer interface.
+// The orders are read using a server-side cursor within a read-only transaction.
+// The read timeout is applied to every page.
+func (s *Storage) Stream(ctx context.Context, q storage.StreamQuery, fn func(page []storage.OrderDB) error) error {
+	if q.PageSize < 1 {
+		return errors.New("page size must be positive")
+	}
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var since, limit interface{}
+	if !q.Since.IsZero() {
+		since = q.Since
+	}
+	if q.Limit > 0 {
+		limit = q.Limit
+	}
+	if _, err := tx.ExecContext(ctx, `DECLARE orders_stream NO SCROLL CURSOR FOR
+		SELECT uid, json_order, version FROM orders
+		WHERE $1::TIMESTAMPTZ IS NULL OR order_created(json_order) >= $1
+		ORDER BY order_created(json_order) DESC NULLS LAST
+		LIMIT $2;`, since, limit); err != nil {
+		return err
+	}
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM orders_stream;", q.PageSize)
+	for {
+		page, err := s.fetchPage(ctx, tx, fetch, q.PageSize)
+		if err != nil {
+			return err
+		}
+		if len(page) == 0 {
+			return nil
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+		if len(page) < q.PageSize {
+			return nil
+		}
+	}
+}
+
+// fetchPage reads the next page from the cursor.
+func (s *Storage) fetchPage(ctx context.Context, tx *sql.Tx, fetch string, pageSize int) ([]storage.OrderDB, error) {
+	ctx, cancel := withTimeout(ctx, s.readTimeout)
+	defer cancel()
+	rows, err := tx.QueryContext(ctx, fetch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	page := make([]storage.OrderDB, 0, pageSize)
+	for rows.Next() {
+		var o storage.OrderDB
+		if err := rows.Scan(&o.OrderUID, &o.JSONOrder, &o.Version); err != nil {
+			return nil, err
+		}
+		page = append(page, o)
+	}
+	return page, rows.Err()
+}
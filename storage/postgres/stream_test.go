@@ -0,0 +1,59 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vanamelnik/wildberries-L0/storage"
+)
+
+func TestStream(t *testing.T) {
+	defer cleanOrdersTable(t)
+	pg, err := NewStorage(context.Background(), pgMockDSN, WithMode(ModeSync))
+	require.NoError(t, err)
+	defer pg.Close()
+	ctx := context.Background()
+	base := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		order := fmt.Sprintf(`{"date_created":%q}`, base.Add(time.Duration(i)*time.Hour).Format(time.RFC3339))
+		require.NoError(t, pg.Store(ctx, fmt.Sprint(i), order))
+	}
+	stream := func(q storage.StreamQuery) ([][]string, error) {
+		pages := [][]string{}
+		err := pg.Stream(ctx, q, func(page []storage.OrderDB) error {
+			uids := make([]string, 0, len(page))
+			for _, o := range page {
+				uids = append(uids, o.OrderUID)
+			}
+			pages = append(pages, uids)
+			return nil
+		})
+		return pages, err
+	}
+
+	t.Run("All", func(t *testing.T) {
+		pages, err := stream(storage.StreamQuery{PageSize: 4})
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"9", "8", "7", "6"}, {"5", "4", "3", "2"}, {"1", "0"}}, pages)
+	})
+	t.Run("Limit", func(t *testing.T) {
+		pages, err := stream(storage.StreamQuery{PageSize: 2, Limit: 3})
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"9", "8"}, {"7"}}, pages)
+	})
+	t.Run("Since", func(t *testing.T) {
+		pages, err := stream(storage.StreamQuery{PageSize: 5, Since: base.Add(7 * time.Hour)})
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{{"9", "8", "7"}}, pages)
+	})
+	t.Run("Stop", func(t *testing.T) {
+		errStop := errors.New("stop")
+		err := pg.Stream(ctx, storage.StreamQuery{PageSize: 1}, func(page []storage.OrderDB) error { return errStop })
+		assert.ErrorIs(t, err, errStop)
+	})
+}
@@ -3,25 +3,113 @@ package storage
 // package storage describes the Storage interface and storage errors.
 
 import (
+	"context"
 	"errors"
+	"time"
 )
 
 type (
 	// Storage represents app's order storage.
+	// All the methods take a context: implementations must stop the operation and return
+	// the context error as soon as the context is canceled or its deadline is exceeded.
+	//
+	// Every order has a version that is set to 1 when the order is stored and incremented on every change.
 	Storage interface {
-		Store(orderUID, jsonOrder string) error
-		Get(orderUID string) (string, error)
-		GetAll() ([]OrderDB, error)
+		// Store inserts a new order. ErrAlreadyExists is returned if the order with the same UID exists.
+		Store(ctx context.Context, orderUID, jsonOrder string) error
+		Get(ctx context.Context, orderUID string) (string, error)
+		// GetRecord returns the order with its version.
+		GetRecord(ctx context.Context, orderUID string) (OrderDB, error)
+		GetAll(ctx context.Context) ([]OrderDB, error)
+		// List returns the page of the sorted orders matching the filter of the query (keyset pagination).
+		// ErrInvalidCursor is returned if the cursor of the query is malformed.
+		List(ctx context.Context, q ListQuery) (ListPage, error)
+		// Update replaces the order if its current version equals the version provided
+		// (optimistic concurrency) and returns the new version. ErrVersionConflict is returned
+		// if the order has been changed since the version was read.
+		Update(ctx context.Context, orderUID, jsonOrder string, version int64) (int64, error)
+		// Upsert stores the order replacing the existing one regardless of its version
+		// and returns the new version. If the same JSON document is already stored, the order is left intact
+		// and its current version is returned.
+		Upsert(ctx context.Context, orderUID, jsonOrder string) (int64, error)
+		// Delete removes the order. ErrNotFound is returned if there is no such order.
+		Delete(ctx context.Context, orderUID string) error
+		// ErasePII erases the personal data of the customer from the order (see models.ErasePII)
+		// keeping the rest of the order, records the erasure to the audit log and returns the new version.
+		// NB the copies of the order outside the storage are not erased, e.g. the rejected messages
+		// in the dead-letter subject of the listener keep the original payload.
+		ErasePII(ctx context.Context, orderUID string, e Erasure) (int64, error)
+	}
+
+	// Erasure is the audit log entry describing the erasure of the personal data of the order.
+	Erasure struct {
+		OrderUID  string
+		Requester string // who has requested the erasure
+		Reason    string
+		ErasedAt  time.Time
 	}
 
 	// OrderDB represents the row in the database for order storing.
 	OrderDB struct {
 		OrderUID  string
 		JSONOrder string
+		Version   int64
+	}
+
+	// Streamer is implemented by the storages that are able to read the orders page by page
+	// without loading all of them into memory.
+	Streamer interface {
+		// Stream reads the orders matching the query, the most recent first,
+		// and calls fn for every page until all the orders are read or fn returns an error.
+		Stream(ctx context.Context, q StreamQuery, fn func(page []OrderDB) error) error
+	}
+
+	// StreamQuery describes the orders to be streamed.
+	StreamQuery struct {
+		PageSize int       // the number of the orders in a page
+		Limit    int       // the maximal number of the orders, 0 means no limit
+		Since    time.Time // only the orders created at or after the time, zero value means all the orders
+	}
+
+	// ChangeNotifier is implemented by the storages shared by several instances of the app
+	// that are able to report the changes of the orders made by any instance.
+	ChangeNotifier interface {
+		// Listen calls fn for every change until the context is canceled.
+		Listen(ctx context.Context, fn func(Change)) error
+	}
+
+	// AuditLog is implemented by the storages that are able to report the recorded erasures.
+	AuditLog interface {
+		// Erasures returns the erasures of the order's personal data, the oldest first.
+		Erasures(ctx context.Context, orderUID string) ([]Erasure, error)
+	}
+
+	// Change describes the change of the order in the storage.
+	Change struct {
+		Op       ChangeOp
+		OrderUID string
+		Version  int64 // the version of the order after the change, 0 if unknown
 	}
+
+	// ChangeOp is the kind of the change.
+	ChangeOp string
+)
+
+// Change kinds.
+const (
+	OpInsert ChangeOp = "INSERT"
+	OpUpdate ChangeOp = "UPDATE"
+	OpDelete ChangeOp = "DELETE"
+	// OpReset means that some changes could have been missed (e.g. after reconnection),
+	// so all the cached orders should be considered stale.
+	OpReset ChangeOp = "RESET"
 )
 
 var (
 	ErrAlreadyExists = errors.New("order already exists")
 	ErrNotFound      = errors.New("order not found")
+	// ErrVersionConflict is returned if the order has been changed by someone else.
+	ErrVersionConflict = errors.New("order version conflict")
+	// ErrNoAuditLog is returned by AuditLog if the erasures are recorded by a storage without the audit log.
+	ErrNoAuditLog = errors.New("storage has no audit log")
 )